@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"marketier/internal/data"
+	"marketier/internal/validator"
+)
+
+// deactivateAccountHandler lets a user switch their own account off. They are signed out
+// everywhere and can't use the account again until they reactivate it.
+func (app *application) deactivateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.BaseUsersModel.SetStatus(user, data.AccountStatusDeactivated)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.invalidTransitionResponse(w, r, user.AccountStatus, data.AccountStatusDeactivated)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reactivateAccountHandler switches a deactivated account back on. A deactivated user
+// can't authenticate, so they prove who they are with their email address and password,
+// and are then logged in as they would be by POST /v1/tokens/authentication.
+func (app *application) reactivateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, ok := app.checkCredentials(w, r, input.Email, input.Password)
+	if !ok {
+		return
+	}
+
+	if user.AccountStatus != data.AccountStatusDeactivated {
+		app.invalidTransitionResponse(w, r, user.AccountStatus, data.AccountStatusActivated)
+		return
+	}
+
+	err = app.models.BaseUsersModel.SetStatus(user, data.AccountStatusActivated)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
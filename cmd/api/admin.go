@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"marketier/internal/data"
+	"marketier/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) listUsersAdminHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search        string
+		AccountStatus string
+		AccountType   int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	input.AccountStatus = app.readString(qs, "account_status", "")
+	input.AccountType = app.readInt(qs, "account_type", 0, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "user_id")
+	input.Filters.SortSafelist = []string{"user_id", "email", "last_name", "account_creation_time", "last_login_time", "-user_id", "-email", "-last_name", "-account_creation_time", "-last_login_time"}
+
+	// An account_type that isn't given searches every type.
+	if qs.Has("account_type") {
+		v.Check(input.AccountType >= 1 && input.AccountType <= 4, "account_type", "must be between 1 and 4")
+	}
+	v.Check(input.AccountStatus == "" || validator.In(input.AccountStatus, data.AccountStatuses...), "account_status", "must be a known account status")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	users, metadata, err := app.models.AdminModel.SearchUsers(input.Search, input.AccountStatus, input.AccountType, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserAdminHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readAdminTarget loads the user named in the URL for the admin handlers, writing the
+// error response itself when the user can't be found.
+func (app *application) readAdminTarget(w http.ResponseWriter, r *http.Request) (*data.BaseUserAccount, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.BaseUsersModel.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// newAuditEntry starts an audit entry for the current admin acting on the target user,
+// validating the reason they gave.
+func (app *application) newAuditEntry(r *http.Request, v *validator.Validator, target *data.BaseUserAccount, action, reason string) *data.AuditEntry {
+	data.ValidateAuditReason(v, reason)
+
+	return &data.AuditEntry{
+		AdminId:      app.contextGetUser(r).UserId,
+		TargetUserId: target.UserId,
+		Action:       action,
+		Reason:       reason,
+	}
+}
+
+// writeAdminActionResult writes the response for an admin action, mapping the data
+// layer's errors the same way for every action.
+func (app *application) writeAdminActionResult(w http.ResponseWriter, r *http.Request, err error, user *data.BaseUserAccount, entry *data.AuditEntry) {
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user, "audit": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setAccountStatus(w, r, data.AccountStatusActivated, data.AccountStatusSuspended, data.AdminActionSuspend)
+}
+
+func (app *application) reinstateUserHandler(w http.ResponseWriter, r *http.Request) {
+	app.setAccountStatus(w, r, data.AccountStatusSuspended, data.AccountStatusActivated, data.AdminActionReinstate)
+}
+
+// setAccountStatus moves the user named in the URL from one status to another, refusing
+// if they aren't currently in the from status.
+func (app *application) setAccountStatus(w http.ResponseWriter, r *http.Request, from, to, action string) {
+	user, ok := app.readAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	entry := app.newAuditEntry(r, v, user, action, input.Reason)
+	v.Check(user.UserId != entry.AdminId, "user_id", "administrators can't change their own account status")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if user.AccountStatus != from {
+		app.invalidTransitionResponse(w, r, user.AccountStatus, to)
+		return
+	}
+
+	err = app.models.AdminModel.SetAccountStatus(user, to, entry)
+	app.writeAdminActionResult(w, r, err, user, entry)
+}
+
+func (app *application) forcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	entry := app.newAuditEntry(r, v, user, data.AdminActionForcePasswordReset, input.Reason)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventPasswordReset, input.Reason)
+
+	token, err := app.models.AdminModel.ForcePasswordReset(user, 45*time.Minute, entry, event)
+	if err != nil {
+		app.writeAdminActionResult(w, r, err, nil, nil)
+		return
+	}
+
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"firstName":          user.FirstName,
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "admin_password_reset.tmpl", emailData)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	app.writeAdminActionResult(w, r, nil, user, entry)
+}
+
+func (app *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	entry := app.newAuditEntry(r, v, user, data.AdminActionRevokeTokens, input.Reason)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.AdminModel.RevokeTokens(user.UserId, entry)
+	app.writeAdminActionResult(w, r, err, user, entry)
+}
+
+func (app *application) changeAccountTypeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readAdminTarget(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		AccountType int8   `json:"account_type"`
+		Reason      string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	entry := app.newAuditEntry(r, v, user, data.AdminActionChangeAccountType, input.Reason)
+	entry.Details = fmt.Sprintf("account type %d to %d", user.AccountType, input.AccountType)
+
+	v.Check(input.AccountType >= 1 && input.AccountType <= 4, "account_type", "account_type id must be 1, 2, 3, or 4")
+	v.Check(input.AccountType != user.AccountType, "account_type", "must be different to the current account type")
+	v.Check(user.UserId != entry.AdminId, "user_id", "administrators can't change their own account type")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventPermissionsChanged, entry.Details)
+
+	err = app.models.AdminModel.ChangeAccountType(user, input.AccountType, entry, event)
+	app.writeAdminActionResult(w, r, err, user, entry)
+}
+
+func (app *application) listAuditTrailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		AdminId      int
+		TargetUserId int
+		Action       string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.AdminId = app.readInt(qs, "admin_id", 0, v)
+	input.TargetUserId = app.readInt(qs, "user_id", 0, v)
+	input.Action = app.readString(qs, "action", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.models.AdminModel.GetAuditTrail(int64(input.AdminId), int64(input.TargetUserId), input.Action, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -121,11 +121,11 @@ func (app *application) activateBaseUserHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	user.AccountStatus = "ACTIVATED"
-
-	err = app.models.BaseUsersModel.Update(user)
+	err = app.models.BaseUsersModel.SetStatus(user, data.AccountStatusActivated)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.invalidTransitionResponse(w, r, user.AccountStatus, data.AccountStatusActivated)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -140,6 +140,8 @@ func (app *application) activateBaseUserHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	app.recordSecurityEvent(r, user.UserId, data.SecurityEventAccountActivated, "")
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -186,7 +188,9 @@ func (app *application) updateBaseUserPasswordHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	err = app.models.BaseUsersModel.Update(user)
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventPasswordReset, "")
+
+	err = app.models.BaseUsersModel.ResetPassword(user, event)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -197,7 +201,8 @@ func (app *application) updateBaseUserPasswordHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.UserId)
+	// Setting a new password also lifts any lockout from failed logins.
+	err = app.models.LoginAttempts.Reset(user.Email)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -282,10 +287,6 @@ func (app *application) updateShoppersHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if input.Email != nil {
-		user.Email = *input.Email
-	}
-
 	if input.Address != nil {
 		user.Address = *input.Address
 	}
@@ -294,8 +295,16 @@ func (app *application) updateShoppersHandler(w http.ResponseWriter, r *http.Req
 		user.Password.Set(*input.Password)
 	}
 
+	// The email address only changes once the new one has been confirmed, which is only
+	// asked for once the rest of the update has been saved.
+	emailChanged := input.Email != nil && *input.Email != user.Email
+
 	v := validator.New()
 
+	if emailChanged {
+		data.ValidateEmail(v, *input.Email)
+	}
+
 	if data.ValidateBaseUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -312,31 +321,20 @@ func (app *application) updateShoppersHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	if emailChanged && !app.requestEmailChange(w, r, user, *input.Email) {
+		return
 	}
-}
 
-func (app *application) deleteBaseUserHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
+	if input.Password != nil {
+		app.recordSecurityEvent(r, user.UserId, data.SecurityEventPasswordChanged, "")
 	}
 
-	err = app.models.BaseUsersModel.Delete(id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
+	env := envelope{"user": user}
+	if emailChanged {
+		env["message"] = emailChangeMessage
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -9,7 +9,12 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	permissionsContextKey = contextKey("permissions")
+	sessionContextKey     = contextKey("session")
+	apiKeyContextKey      = contextKey("apiKey")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.BaseUserAccount) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +29,42 @@ func (app *application) contextGetUser(r *http.Request) *data.BaseUserAccount {
 
 	return user
 }
+
+func (app *application) contextSetPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), permissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetPermissions(r *http.Request) data.Permissions {
+	permissions, ok := r.Context().Value(permissionsContextKey).(data.Permissions)
+	if !ok {
+		panic("missing permissions value in request context")
+	}
+
+	return permissions
+}
+
+// contextSetSessionID stores the id of the session the request's access token belongs
+// to, so that the current session can be told apart from the user's others.
+func (app *application) contextSetSessionID(r *http.Request, sessionID int64) *http.Request {
+	ctx := context.WithValue(r.Context(), sessionContextKey, sessionID)
+	return r.WithContext(ctx)
+}
+
+// contextGetSessionID returns 0 when the request wasn't authenticated with a token.
+func (app *application) contextGetSessionID(r *http.Request) int64 {
+	sessionID, _ := r.Context().Value(sessionContextKey).(int64)
+	return sessionID
+}
+
+// contextSetAPIKey stores the API key the request was authenticated with.
+func (app *application) contextSetAPIKey(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns nil when the request wasn't authenticated with an API key.
+func (app *application) contextGetAPIKey(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
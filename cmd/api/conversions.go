@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"marketier/internal/data"
+	"marketier/internal/validator"
+	"net/http"
+	"time"
+)
+
+func (app *application) registerConversionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		OrderId    string     `json:"order_id"`
+		ProductId  int64      `json:"product_id"`
+		OrderTotal data.Money `json:"order_total"`
+		IPAddress  string     `json:"ip_address"`
+		OccurredAt *time.Time `json:"occurred_at"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	conversion := &data.Conversion{
+		OrderId:    input.OrderId,
+		ProductId:  input.ProductId,
+		OrderTotal: input.OrderTotal,
+		OwnerId:    app.contextGetUser(r).UserId,
+		OccurredAt: time.Now(),
+	}
+
+	if input.OccurredAt != nil {
+		conversion.OccurredAt = *input.OccurredAt
+	}
+
+	v := validator.New()
+
+	v.Check(input.IPAddress != "", "ip_address", "must be provided")
+
+	if data.ValidateConversion(v, conversion); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	product, err := app.models.ProductModel.Get(conversion.ProductId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "no matching product found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if product.OwnerId != conversion.OwnerId {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	click, marketierId, err := app.models.ConversionModel.FindAttributedClick(product.ProductId, app.hashIP(input.IPAddress), conversion.OccurredAt, app.config.attribution.window, app.config.attribution.model)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("ip_address", "no tracking click found within the attribution window")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	conversion.MarketierId = marketierId
+	conversion.LinkId = click.LinkId
+	conversion.ClickId = click.ClickId
+	conversion.Commission = conversion.OrderTotal.Percent(app.config.commission.rateBasisPoints)
+
+	err = app.models.ConversionModel.Insert(conversion)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateOrder):
+			v.AddError("order_id", "a conversion with this order id has already been recorded")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"conversion": conversion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"marketier/internal/data"
+	"marketier/internal/validator"
+
+	"github.com/tomasen/realip"
+)
+
+// deviceFingerprint identifies the device a request came from by its IP address and user
+// agent, keyed like hashIP so that the stored fingerprints can't be reversed. Nothing is
+// stored on the device itself, which keeps the API free of cookies, but it means the same
+// device counts as a new one whenever its address changes, such as on another network or
+// after a browser update, and users are emailed about those logins too. That errs towards
+// telling users about a login they made rather than missing one they didn't.
+func (app *application) deviceFingerprint(r *http.Request) []byte {
+	return app.hashIP(realip.FromRequest(r) + "\n" + r.UserAgent())
+}
+
+// describeDevice turns a user agent into a rough description like "Firefox on Windows",
+// which is all a user needs to recognise the device.
+func describeDevice(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+
+	systems := []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+
+	browser := ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := ""
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return "a browser on " + system
+	default:
+		return "an unknown device"
+	}
+}
+
+// notifyNewDevice emails the user when they log in from a device they haven't used
+// before, with a token to report the login if it wasn't them. Failing to check the device
+// is logged rather than failing the login.
+func (app *application) notifyNewDevice(r *http.Request, user *data.BaseUserAccount) {
+	isNew, err := app.models.KnownDevices.Remember(user.UserId, app.deviceFingerprint(r))
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	if !isNew {
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.UserId, 7*24*time.Hour, data.ScopeLoginReport)
+	if err != nil {
+		app.logError(r, err)
+		return
+	}
+
+	ip := realip.FromRequest(r)
+	device := describeDevice(r.UserAgent())
+	loginTime := time.Now().UTC().Format("2 January 2006 at 15:04 MST")
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"firstName":   user.FirstName,
+			"loginTime":   loginTime,
+			"device":      device,
+			"ip":          ip,
+			"reportToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "new_device_login.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+}
+
+// reportLoginHandler takes the token from a new device email when the user says the login
+// wasn't them. They're signed out everywhere and their password is scrambled, and they're
+// emailed a password reset token to choose a new one.
+func (app *application) reportLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.BaseUsersModel.GetForToken(data.ScopeLoginReport, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired report token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventLoginReported, "")
+
+	token, err := app.models.KnownDevices.ReportLogin(user, 45*time.Minute, event)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.recordSecurityEvent(r, user.UserId, data.SecurityEventTokenCreated, data.ScopePasswordReset)
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "you have been signed out everywhere, and an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
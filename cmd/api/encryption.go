@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// reencryptionBatchSize is how many users are re-encrypted in each transaction, so that
+// no row stays locked for long while the API carries on serving requests.
+const reencryptionBatchSize = 100
+
+// runReencryption moves every user's personal details on to the current encryption key,
+// a batch at a time, and encrypts any still stored as plaintext. It stops once it has been
+// through every user, or when done is closed, in which case running it again starts over.
+func (app *application) runReencryption(done <-chan struct{}) {
+	app.logger.PrintInfo("re-encrypting personal details", map[string]string{"key_id": app.models.BaseUsersModel.Keyring.CurrentId()})
+
+	var lastID int64
+	total := 0
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		nextID, updated, err := app.models.BaseUsersModel.ReencryptPersonalDetails(lastID, reencryptionBatchSize)
+		if err != nil {
+			// A batch that fails is retried after a pause, rather than skipped and left
+			// under the old key.
+			app.logger.PrintError(err, map[string]string{"after_user_id": fmt.Sprint(lastID)})
+
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Minute):
+			}
+			continue
+		}
+
+		total += updated
+
+		if nextID == 0 {
+			break
+		}
+
+		lastID = nextID
+	}
+
+	app.logger.PrintInfo("personal details re-encrypted", map[string]string{"users_updated": fmt.Sprint(total)})
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -57,6 +60,14 @@ func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
 }
 
+// loginBlockedResponse tells the client how long to wait before trying to log in again.
+func (app *application) loginBlockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+}
+
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -69,6 +80,48 @@ func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
 }
 
+func (app *application) invalidRefreshTokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired refresh token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) refreshTokenReusedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this refresh token has already been used, so the session has been revoked for your security; please log in again"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidMFATokenResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or expired mfa token, please log in again"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) invalidSecondFactorResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or already used two-factor code"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) oidcLoginFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "signing in with the provider failed, please try again"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) unverifiedOIDCEmailResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the provider has not verified an email address for your account"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+
+	message := "invalid or expired API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
 	app.errorResponse(w, r, http.StatusUnauthorized, message)
@@ -79,7 +132,22 @@ func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.R
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
 
+func (app *application) suspendedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been suspended, please contact support"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+func (app *application) deactivatedAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account has been deactivated, reactivate it to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) invalidTransitionResponse(w http.ResponseWriter, r *http.Request, from, to string) {
+	message := fmt.Sprintf("unable to move from %q to %q", from, to)
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"marketier/internal/validator"
 
@@ -167,6 +170,23 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readTime reads an RFC 3339 timestamp, returning the zero time if the key is missing.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 
@@ -184,6 +204,14 @@ func (app *application) background(fn func()) {
 	}()
 }
 
+// hashIP keys the hash with a server secret so that stored hashes can't be reversed by
+// hashing every possible address.
+func (app *application) hashIP(ip string) []byte {
+	mac := hmac.New(sha256.New, []byte(app.config.tracking.ipHashKey))
+	mac.Write([]byte(ip))
+	return mac.Sum(nil)
+}
+
 type ParseFormData struct {
 	FileNames []string
 	Files     []multipart.File
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"marketier/internal/data"
+	"marketier/internal/validator"
+
+	"github.com/tomasen/realip"
+)
+
+// checkLoginAllowed refuses the login if the email address or the client's IP address is
+// backing off or locked out after failed attempts. The same response is sent whether or
+// not there is an account for the email address.
+func (app *application) checkLoginAllowed(w http.ResponseWriter, r *http.Request, email string) bool {
+	wait, err := app.models.LoginAttempts.Blocked(email, realip.FromRequest(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if wait > 0 {
+		app.loginBlockedResponse(w, r, wait)
+		return false
+	}
+
+	return true
+}
+
+// recordLoginFailure counts a failed login against the email address and the client's IP
+// address. When that locks an existing account out, its owner is emailed a link to unlock
+// it straight away.
+func (app *application) recordLoginFailure(r *http.Request, email string, user *data.BaseUserAccount) error {
+	var userID int64
+	if user != nil {
+		userID = user.UserId
+	}
+
+	app.recordSecurityEvent(r, userID, data.SecurityEventLoginFailed, "")
+
+	locked, err := app.models.LoginAttempts.RecordFailure(email, realip.FromRequest(r), app.config.login.policy)
+	if err != nil {
+		return err
+	}
+
+	if !locked || user == nil {
+		return nil
+	}
+
+	token, err := app.models.Tokens.New(user.UserId, 24*time.Hour, data.ScopeUnlock)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"firstName":       user.FirstName,
+			"unlockToken":     token.Plaintext,
+			"lockoutDuration": app.config.login.policy.LockoutDuration.String(),
+		}
+
+		err := app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	return nil
+}
+
+// unlockAccountHandler lifts a lockout early using the token from the lockout email.
+func (app *application) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.BaseUsersModel.GetForToken(data.ScopeUnlock, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired unlock token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.LoginAttempts.Reset(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeUnlock, user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "your account has been unlocked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runLoginAttemptCleanup periodically removes failed login records that no longer block
+// anything, until done is closed.
+func (app *application) runLoginAttemptCleanup(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := app.models.LoginAttempts.DeleteStale(app.config.login.policy)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
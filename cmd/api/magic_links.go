@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"marketier/internal/data"
+	"marketier/internal/validator"
+)
+
+// createMagicLinkTokenHandler emails a single-use login link. The response is the same
+// whether or not there is an activated account for the address, and an address that has
+// already been sent the most links allowed in the last hour quietly gets no more.
+func (app *application) createMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	env := envelope{"message": "if an activated account uses this email address, a login link will be sent to it"}
+
+	user, err := app.models.BaseUsersModel.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user == nil || user.AccountStatus != data.AccountStatusActivated {
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	sent, err := app.models.Tokens.CountRecentForUser(data.ScopeMagicLink, user.UserId, time.Hour)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if sent < app.config.magicLink.maxPerHour {
+		token, err := app.models.Tokens.New(user.UserId, app.config.magicLink.ttl, data.ScopeMagicLink)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.recordSecurityEvent(r, user.UserId, data.SecurityEventTokenCreated, data.ScopeMagicLink)
+
+		app.background(func() {
+			data := map[string]interface{}{
+				"firstName":      user.FirstName,
+				"magicLinkToken": token.Plaintext,
+				"ttl":            app.config.magicLink.ttl.String(),
+			}
+
+			err := app.mailer.Send(user.Email, "token_magic_link.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// exchangeMagicLinkTokenHandler logs the user in with the token from a login link. Every
+// outstanding link for the user stops working once one of them has been used.
+func (app *application) exchangeMagicLinkTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.BaseUsersModel.GetForToken(data.ScopeMagicLink, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired login link token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMagicLink, user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
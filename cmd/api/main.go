@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -12,9 +13,12 @@ import (
 	"sync"
 	"time"
 
+	"marketier/internal/auth/oidc"
+	"marketier/internal/crypto"
 	"marketier/internal/data"
 	"marketier/internal/jsonlog"
 	"marketier/internal/mailer"
+	"marketier/internal/moderation"
 
 	_ "github.com/lib/pq"
 )
@@ -49,14 +53,80 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+
+	tracking struct {
+		productURL string
+		ipHashKey  string
+	}
+
+	attribution struct {
+		window time.Duration
+		model  string
+	}
+
+	commission struct {
+		rateBasisPoints int64
+	}
+
+	tiers struct {
+		interval time.Duration
+		policy   data.TierPolicy
+	}
+
+	moderation struct {
+		bannedWords []string
+	}
+
+	messages struct {
+		notifyInterval time.Duration
+	}
+
+	tokens struct {
+		accessTTL  time.Duration
+		refreshTTL time.Duration
+	}
+
+	mfa struct {
+		issuer string
+	}
+
+	login struct {
+		policy data.LoginPolicy
+	}
+
+	magicLink struct {
+		ttl        time.Duration
+		maxPerHour int
+	}
+
+	oidc struct {
+		providers   []oidc.Config
+		redirectURL string
+	}
+
+	privacy struct {
+		exportDir           string
+		exportTTL           time.Duration
+		exportInterval      time.Duration
+		deletionGracePeriod time.Duration
+	}
+
+	encryption struct {
+		keys         map[string][]byte
+		currentKeyId string
+		reencrypt    bool
+		require      bool
+	}
 }
 
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
+	config        config
+	logger        *jsonlog.Logger
+	models        data.Models
+	mailer        mailer.Mailer
+	moderator     *moderation.Engine
+	oidcProviders map[string]oidc.Provider
+	wg            sync.WaitGroup
 }
 
 func main() {
@@ -92,6 +162,81 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&cfg.tracking.productURL, "tracking-product-url", "http://localhost:4001/products/%d", "Product page that tracking links redirect to (%d is replaced by the product ID)")
+	flag.StringVar(&cfg.tracking.ipHashKey, "tracking-ip-hash-key", "", "Secret key used to hash visitor IP addresses and device fingerprints, at least 32 bytes long (required)")
+
+	flag.DurationVar(&cfg.attribution.window, "attribution-window", 30*24*time.Hour, "How long after a click a sale can still be attributed to it")
+	flag.StringVar(&cfg.attribution.model, "attribution-model", data.AttributionLastClick, "Attribution model (last-click|first-click)")
+
+	flag.Int64Var(&cfg.commission.rateBasisPoints, "commission-rate-bps", 1000, "Marketier commission on each attributed sale, in basis points")
+
+	flag.DurationVar(&cfg.tiers.interval, "tier-interval", 24*time.Hour, "How often marketier tiers are recalculated")
+
+	// Each tier is written as sales:rating:days, the minimum sales generated, average
+	// product rating and account age in days needed to reach it, starting at tier 1.
+	cfg.tiers.policy = data.DefaultTierPolicy
+	flag.Func("tier-policy", "Tier thresholds as space separated sales:rating:days entries (default \""+data.DefaultTierPolicy.String()+"\")", func(val string) error {
+		policy, err := data.ParseTierPolicy(val)
+		if err != nil {
+			return err
+		}
+		cfg.tiers.policy = policy
+		return nil
+	})
+
+	cfg.moderation.bannedWords = moderation.DefaultBannedWords
+	flag.Func("moderation-banned-words", "Words that send a review to the moderation queue (space separated)", func(val string) error {
+		cfg.moderation.bannedWords = strings.Fields(val)
+		return nil
+	})
+
+	flag.DurationVar(&cfg.messages.notifyInterval, "messages-notify-interval", 15*time.Minute, "How often participants are emailed about unread messages")
+
+	flag.DurationVar(&cfg.tokens.accessTTL, "token-access-ttl", 15*time.Minute, "How long an authentication token is valid for")
+	flag.DurationVar(&cfg.tokens.refreshTTL, "token-refresh-ttl", 30*24*time.Hour, "How long a refresh token is valid for")
+
+	flag.StringVar(&cfg.mfa.issuer, "mfa-issuer", "MarkeTier", "Issuer name shown in authenticator apps")
+
+	flag.IntVar(&cfg.login.policy.FreeAttempts, "login-free-attempts", 3, "Failed logins allowed before backoff starts")
+	flag.DurationVar(&cfg.login.policy.BackoffBase, "login-backoff-base", time.Second, "Wait after the first failed login past the free attempts, doubling with each further failure")
+	flag.IntVar(&cfg.login.policy.MaxFailures, "login-max-failures", 10, "Failed logins for an email address before it is locked out")
+	flag.IntVar(&cfg.login.policy.MaxIPFailures, "login-max-ip-failures", 100, "Failed logins from an IP address before it is locked out")
+	flag.DurationVar(&cfg.login.policy.LockoutDuration, "login-lockout-duration", 30*time.Minute, "How long a lockout lasts")
+
+	flag.DurationVar(&cfg.magicLink.ttl, "magic-link-ttl", 15*time.Minute, "How long a login link is valid for")
+	flag.IntVar(&cfg.magicLink.maxPerHour, "magic-link-max-per-hour", 3, "Login links an email address can be sent each hour")
+
+	flag.Func("oidc-provider", "OpenID Connect provider as \"name issuer client-id client-secret\" (may be repeated)", func(val string) error {
+		provider, err := oidc.ParseConfig(val)
+		if err != nil {
+			return err
+		}
+		cfg.oidc.providers = append(cfg.oidc.providers, provider)
+		return nil
+	})
+	flag.StringVar(&cfg.oidc.redirectURL, "oidc-redirect-url", "http://localhost:4000/v1/auth/oidc/%s/callback", "Callback URL registered with OpenID Connect providers (%s is replaced by the provider name)")
+
+	flag.StringVar(&cfg.privacy.exportDir, "privacy-export-dir", "../internals/exports", "Directory that data export archives are written to")
+	flag.DurationVar(&cfg.privacy.exportTTL, "privacy-export-ttl", 7*24*time.Hour, "How long a data export can be downloaded for")
+	flag.DurationVar(&cfg.privacy.exportInterval, "privacy-export-interval", 24*time.Hour, "How long a user has to wait between data exports")
+	flag.DurationVar(&cfg.privacy.deletionGracePeriod, "privacy-deletion-grace-period", 30*24*time.Hour, "How long after a deletion request an account is erased")
+
+	// Personal details are encrypted with the current key. Keys that have been rotated out
+	// are kept so that values encrypted with them can still be read, until -encryption-reencrypt
+	// has moved everything on to the current key.
+	cfg.encryption.keys = make(map[string][]byte)
+	flag.Func("encryption-key", "Key for encrypting personal details as \"id:base64-key\", 32 bytes long (may be repeated)", func(val string) error {
+		id, key, err := crypto.ParseKey(val)
+		if err != nil {
+			return err
+		}
+		cfg.encryption.keys[id] = key
+		return nil
+	})
+	flag.StringVar(&cfg.encryption.currentKeyId, "encryption-key-id", "", "Id of the key new values are encrypted with (defaults to the only key given)")
+	flag.BoolVar(&cfg.encryption.reencrypt, "encryption-reencrypt", false, "Re-encrypt stored personal details with the current key in the background, after rotating keys")
+	flag.BoolVar(&cfg.encryption.require, "encryption-require", false, "Treat personal details stored as plaintext as an error, once -encryption-reencrypt has encrypted them all")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 
 	flag.Parse()
@@ -105,6 +250,34 @@ func main() {
 
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	if cfg.commission.rateBasisPoints < 0 || cfg.commission.rateBasisPoints > 10000 {
+		logger.PrintFatal(fmt.Errorf("invalid commission rate %d, must be between 0 and 10000 basis points", cfg.commission.rateBasisPoints), nil)
+	}
+
+	// Without a long secret key the hashes could be reversed by hashing every address.
+	if len(cfg.tracking.ipHashKey) < 32 {
+		logger.PrintFatal(errors.New("-tracking-ip-hash-key must be at least 32 bytes long"), nil)
+	}
+
+	if cfg.attribution.model != data.AttributionLastClick && cfg.attribution.model != data.AttributionFirstClick {
+		logger.PrintFatal(fmt.Errorf("invalid attribution model %q", cfg.attribution.model), nil)
+	}
+
+	if cfg.encryption.currentKeyId == "" && len(cfg.encryption.keys) == 1 {
+		for id := range cfg.encryption.keys {
+			cfg.encryption.currentKeyId = id
+		}
+	}
+
+	keyring, err := crypto.NewKeyring(cfg.encryption.currentKeyId, cfg.encryption.keys)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	if cfg.encryption.require {
+		keyring.RequireEncrypted()
+	}
+
 	db, err := openDB(cfg)
 	if err != nil {
 		logger.PrintFatal(err, nil)
@@ -129,10 +302,12 @@ func main() {
 	}))
 
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:        cfg,
+		logger:        logger,
+		models:        data.NewModels(db, keyring),
+		mailer:        mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		moderator:     moderation.New(cfg.moderation.bannedWords),
+		oidcProviders: discoverOIDCProviders(cfg, logger),
 	}
 
 	err = app.serve()
@@ -141,6 +316,29 @@ func main() {
 	}
 }
 
+// discoverOIDCProviders sets up the configured OpenID Connect providers. A provider that
+// can't be reached is left out rather than stopping the API from starting.
+func discoverOIDCProviders(cfg config, logger *jsonlog.Logger) map[string]oidc.Provider {
+	providers := make(map[string]oidc.Provider)
+
+	for _, providerConfig := range cfg.oidc.providers {
+		providerConfig.RedirectURL = fmt.Sprintf(cfg.oidc.redirectURL, providerConfig.Name)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.Discover(ctx, providerConfig)
+		cancel()
+
+		if err != nil {
+			logger.PrintError(err, nil)
+			continue
+		}
+
+		providers[provider.Name()] = provider
+	}
+
+	return providers
+}
+
 func openDB(cfg config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.db.dsn)
 	if err != nil {
@@ -155,10 +155,6 @@ func (app *application) updateMarketiersHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	if input.Email != nil {
-		user.BaseUserAccount.Email = *input.Email
-	}
-
 	if input.Address != nil {
 		user.BaseUserAccount.Address = *input.Address
 	}
@@ -175,8 +171,16 @@ func (app *application) updateMarketiersHandler(w http.ResponseWriter, r *http.R
 		user.About = *input.About
 	}
 
+	// The email address only changes once the new one has been confirmed, which is only
+	// asked for once the rest of the update has been saved.
+	emailChanged := input.Email != nil && *input.Email != user.BaseUserAccount.Email
+
 	v := validator.New()
 
+	if emailChanged {
+		data.ValidateEmail(v, *input.Email)
+	}
+
 	if data.ValidateMarketierUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -193,7 +197,20 @@ func (app *application) updateMarketiersHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if emailChanged && !app.requestEmailChange(w, r, &user.BaseUserAccount, *input.Email) {
+		return
+	}
+
+	if input.Password != nil {
+		app.recordSecurityEvent(r, user.BaseUserAccount.UserId, data.SecurityEventPasswordChanged, "")
+	}
+
+	env := envelope{"user": user}
+	if emailChanged {
+		env["message"] = emailChangeMessage
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -206,6 +223,22 @@ func (app *application) uploadProposalImageHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
+	proposal, err := app.models.ProposalModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.ownsOrPermitted(r, proposal.MarketierId, "proposals:manage") {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	var input ParseFormData
 	input.FileNames = []string{"proposal_image"}
 	err = app.parseMultipartForm(w, r, &input)
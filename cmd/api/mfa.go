@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"marketier/internal/data"
+	"marketier/internal/totp"
+	"marketier/internal/validator"
+)
+
+// maxMFATokenAttempts is how many wrong codes can be entered against one mfa token before
+// it's deleted and the user has to log in again with their password.
+const maxMFATokenAttempts = 5
+
+// checkSecondFactor accepts either a code from the user's authenticator app or one of
+// their recovery codes. A code is only accepted once, even within its time step.
+func (app *application) checkSecondFactor(mfa *data.MFA, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return app.models.MFA.UseRecoveryCode(mfa.UserID, recoveryCode)
+	}
+
+	step, ok, err := totp.Validate(mfa.Secret, code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return app.models.MFA.UseStep(mfa.UserID, step)
+}
+
+func (app *application) showMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	mfa, err := app.models.MFA.Get(user.UserId)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	env := envelope{"enabled": false}
+
+	if mfa != nil && mfa.Enabled() {
+		remaining, err := app.models.MFA.RemainingRecoveryCodes(user.UserId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env = envelope{"enabled": true, "confirmed_at": mfa.ConfirmedAt, "recovery_codes_remaining": remaining}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enrolMFAHandler generates a new secret for the user to add to their authenticator app.
+// Nothing changes at login until the enrolment is confirmed with a code.
+func (app *application) enrolMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.MFA.Enrol(user.UserId, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			v := validator.New()
+			v.AddError("mfa", "two-factor authentication is already enabled")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"secret": secret,
+		"uri":    totp.URI(app.config.mfa.issuer, user.Email, secret),
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// confirmMFAHandler turns two-factor authentication on once the user proves their
+// authenticator app produces the right codes, and hands out their recovery codes.
+func (app *application) confirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTOTPCode(v, input.Code); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	mfa, err := app.models.MFA.Get(user.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("mfa", "enrolment must be started first")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled() {
+		v.AddError("mfa", "two-factor authentication is already enabled")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	step, ok, err := totp.Validate(mfa.Secret, input.Code, time.Now())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		v.AddError("code", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	codes, err := app.models.MFA.Confirm(user.UserId, step)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrMFAAlreadyEnabled):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"recovery_codes": codes,
+		"message":        "two-factor authentication is now enabled; store these recovery codes somewhere safe, they will not be shown again",
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableMFAHandler turns two-factor authentication off. Once it has been confirmed, a
+// current code or a recovery code is needed so that a stolen session can't remove it.
+func (app *application) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	mfa, err := app.models.MFA.Get(user.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if mfa.Enabled() {
+		v := validator.New()
+
+		if data.ValidateSecondFactor(v, input.Code, input.RecoveryCode); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		ok, err := app.checkSecondFactor(mfa, input.Code, input.RecoveryCode)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !ok {
+			app.invalidSecondFactorResponse(w, r)
+			return
+		}
+	}
+
+	err = app.models.MFA.Disable(user.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler is the second step of logging in with two-factor
+// authentication: it swaps the mfa token from the first step and a code for an
+// authentication token.
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateTokenPlaintext(v, input.MFAToken)
+	data.ValidateSecondFactor(v, input.Code, input.RecoveryCode)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.BaseUsersModel.GetForToken(data.ScopeMFAPending, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidMFATokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkLoginAllowed(w, r, user.Email) {
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(user.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidMFATokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ok, err := app.checkSecondFactor(mfa, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		err = app.recordLoginFailure(r, user.Email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		exhausted, err := app.models.Tokens.RecordFailedAttempt(data.ScopeMFAPending, input.MFAToken, maxMFATokenAttempts)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if exhausted {
+			app.invalidMFATokenResponse(w, r)
+			return
+		}
+
+		app.invalidSecondFactorResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeMFAPending, user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.startSession(w, r, user)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/sha256"
 	"errors"
 	"expvar"
 	"fmt"
@@ -93,43 +94,156 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		if authorizationHeader == "" {
 			r = app.contextSetUser(r, data.AnonymousUserAccount)
+			r = app.contextSetPermissions(r, data.Permissions{})
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		if len(headerParts) != 2 {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		token := headerParts[1]
+		var ok bool
 
-		v := validator.New()
-
-		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		switch headerParts[0] {
+		case "Bearer":
+			r, ok = app.authenticateToken(w, r, headerParts[1])
+		case "ApiKey":
+			r, ok = app.authenticateAPIKey(w, r, headerParts[1])
+		default:
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		user, err := app.models.BaseUsersModel.GetForToken(data.ScopeAuthentication, token)
-		if err != nil {
-			switch {
-			case errors.Is(err, data.ErrRecordNotFound):
-				app.invalidAuthenticationTokenResponse(w, r)
-			default:
-				app.serverErrorResponse(w, r, err)
-			}
+		if !ok {
 			return
 		}
 
-		r = app.contextSetUser(r, user)
-
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateToken looks up the user a bearer token was issued to and loads their
+// permissions into the request context.
+func (app *application) authenticateToken(w http.ResponseWriter, r *http.Request, token string) (*http.Request, bool) {
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return r, false
+	}
+
+	user, err := app.models.BaseUsersModel.GetForToken(data.ScopeAuthentication, token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return r, false
+	}
+
+	if !app.checkAccountStatus(w, r, user) {
+		return r, false
+	}
+
+	// Load the user's permissions once here so that the middleware and handlers
+	// further down can check them without going back to the database.
+	permissions, err := app.models.Permissions.GetAllForUser(user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return r, false
+	}
+
+	tokenHash := sha256.Sum256([]byte(token))
+
+	// Failing to record when a session was last used shouldn't fail the request.
+	sessionID, err := app.models.Tokens.Touch(tokenHash[:])
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, permissions)
+	r = app.contextSetSessionID(r, sessionID)
+
+	return r, true
+}
+
+// authenticateAPIKey looks up an API key and acts as its owner, with only the permissions
+// in the key's scopes that the owner still holds.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plaintext string) (*http.Request, bool) {
+	v := validator.New()
+
+	if data.ValidateAPIKeyPlaintext(v, plaintext); !v.Valid() {
+		app.invalidAPIKeyResponse(w, r)
+		return r, false
+	}
+
+	key, err := app.models.APIKeys.GetForKey(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return r, false
+	}
+
+	user, err := app.models.BaseUsersModel.GetById(key.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAPIKeyResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return r, false
+	}
+
+	if !app.checkAccountStatus(w, r, user) {
+		return r, false
+	}
+
+	ownerPermissions, err := app.models.Permissions.GetAllForUser(user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return r, false
+	}
+
+	r = app.contextSetUser(r, user)
+	r = app.contextSetPermissions(r, key.Permissions(ownerPermissions))
+	r = app.contextSetAPIKey(r, key)
+
+	return r, true
+}
+
+// checkAccountStatus turns away users whose account has been suspended or deactivated,
+// telling them which.
+func (app *application) checkAccountStatus(w http.ResponseWriter, r *http.Request, user *data.BaseUserAccount) bool {
+	switch user.AccountStatus {
+	case data.AccountStatusSuspended:
+		app.suspendedAccountResponse(w, r)
+		return false
+	case data.AccountStatusDeactivated:
+		app.deactivatedAccountResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// requireAuthenticatedUser turns away anonymous requests, and requests made with an API
+// key, since keys can only be used on the routes requirePermission guards.
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.authenticatedUser(next, false)
+}
+
+func (app *application) authenticatedUser(next http.HandlerFunc, allowAPIKey bool) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
@@ -138,15 +252,28 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 			return
 		}
 
+		if !allowAPIKey && app.contextGetAPIKey(r) != nil {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
 func (app *application) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return app.activatedUser(next, false)
+}
+
+func (app *application) activatedUser(next http.HandlerFunc, allowAPIKey bool) http.HandlerFunc {
 	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		if user.AccountStatus != "ACTIVATED" {
+		if !app.checkAccountStatus(w, r, user) {
+			return
+		}
+
+		if user.AccountStatus != data.AccountStatusActivated {
 			app.inactiveAccountResponse(w, r)
 			return
 		}
@@ -154,39 +281,61 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 		next.ServeHTTP(w, r)
 	})
 
-	return app.requireAuthenticatedUser(fn)
+	return app.authenticatedUser(fn, allowAPIKey)
 }
 
-// ACcount type of 0 means only self can access
-func (app *application) requirePermission(account_types []int8, next http.HandlerFunc) http.HandlerFunc {
+func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	// The permission check is what limits an API key to its scopes.
+	return app.permittedUser(code, next, true)
+}
+
+// requireAdminPermission is requirePermission for the admin routes, which have to be
+// called by an administrator who has logged in, never with an API key.
+func (app *application) requireAdminPermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	return app.permittedUser(code, next, false)
+}
+
+func (app *application) permittedUser(code string, next http.HandlerFunc, allowAPIKey bool) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		user := app.contextGetUser(r)
-		if account_types[0] == 0 {
-			id, err := app.readIDParam(r)
-			if err != nil {
-				app.notFoundResponse(w, r)
-				return
-			}
-			if id == user.UserId {
-				next.ServeHTTP(w, r)
-				return
-			}
+		if !app.contextGetPermissions(r).Include(code) {
+			app.notPermittedResponse(w, r)
+			return
 		}
-		for _, value := range account_types {
-			if value == user.AccountType {
-				next.ServeHTTP(w, r)
-				return
-			}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.activatedUser(fn, allowAPIKey)
+}
+
+// requireOwnership lets the request through when the :id URL parameter is the current
+// user's own id. Anyone else needs the given permission code, which is how administrators
+// act on other people's accounts.
+func (app *application) requireOwnership(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
 		}
 
-		app.notPermittedResponse(w, r)
-		return
+		if !app.ownsOrPermitted(r, id, code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
 
+		next.ServeHTTP(w, r)
 	}
 
 	return app.requireActivatedUser(fn)
 }
 
+// ownsOrPermitted reports whether the current user is ownerId or holds the permission
+// code that overrides ownership.
+func (app *application) ownsOrPermitted(r *http.Request, ownerId int64, code string) bool {
+	return app.contextGetUser(r).UserId == ownerId || app.contextGetPermissions(r).Include(code)
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
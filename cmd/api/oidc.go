@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"marketier/internal/auth/oidc"
+	"marketier/internal/data"
+	"marketier/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// readOIDCProvider looks up the provider named in the route, sending a 404 if there isn't
+// one configured by that name.
+func (app *application) readOIDCProvider(w http.ResponseWriter, r *http.Request) (oidc.Provider, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	provider, ok := app.oidcProviders[params.ByName("provider")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return provider, true
+}
+
+func (app *application) listOIDCProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range app.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"providers": names}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// startOIDCLoginHandler returns the provider URL to send the user to. The provider sends
+// them back to the callback route once they have signed in.
+func (app *application) startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.readOIDCProvider(w, r)
+	if !ok {
+		return
+	}
+
+	state, nonce, err := app.models.Identities.NewLoginState(provider.Name(), 10*time.Minute)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"authorization_url": provider.AuthURL(state, nonce)}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// oidcCallbackHandler finishes signing in with the provider: it swaps the code for an ID
+// token, verifies it, and logs in the user linked to the identity, linking or creating
+// one by the verified email address the first time.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := app.readOIDCProvider(w, r)
+	if !ok {
+		return
+	}
+
+	qs := r.URL.Query()
+
+	if qs.Get("error") != "" {
+		app.oidcLoginFailedResponse(w, r)
+		return
+	}
+
+	code := app.readString(qs, "code", "")
+	state := app.readString(qs, "state", "")
+
+	v := validator.New()
+	v.Check(code != "", "code", "must be provided")
+	v.Check(state != "", "state", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	nonce, err := app.models.Identities.ConsumeLoginState(provider.Name(), state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("state", "invalid or expired sign-in, please start again")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	rawIDToken, err := provider.Exchange(ctx, code)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchange), errors.Is(err, oidc.ErrProvider):
+			app.logError(r, err)
+			app.oidcLoginFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := provider.Verify(ctx, rawIDToken, nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrInvalidToken), errors.Is(err, oidc.ErrProvider):
+			app.logError(r, err)
+			app.oidcLoginFailedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if data.ValidateEmail(v, claims.Email); !v.Valid() || !claims.EmailVerified {
+		app.unverifiedOIDCEmailResponse(w, r)
+		return
+	}
+
+	user, err := app.findOrCreateOIDCUser(provider.Name(), claims)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// findOrCreateOIDCUser returns the user linked to the identity. Two first sign-ins with
+// the same identity can race, and the one that loses trips over the unique keys the other
+// has just written, so it looks again and finds the identity or account the other made.
+func (app *application) findOrCreateOIDCUser(provider string, claims *oidc.Claims) (*data.BaseUserAccount, error) {
+	user, err := app.linkOIDCUser(provider, claims)
+	if errors.Is(err, data.ErrDuplicateIdentity) || errors.Is(err, data.ErrDuplicateEmail) {
+		user, err = app.linkOIDCUser(provider, claims)
+	}
+
+	return user, err
+}
+
+// linkOIDCUser looks up the user linked to the identity, linking it to the user with the
+// same email address or creating one for it if there isn't one yet.
+func (app *application) linkOIDCUser(provider string, claims *oidc.Claims) (*data.BaseUserAccount, error) {
+	userID, err := app.models.Identities.GetUserID(provider, claims.Subject)
+	if err == nil {
+		return app.models.BaseUsersModel.GetById(userID)
+	}
+	if !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	identity := &data.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	}
+
+	user, err := app.models.BaseUsersModel.GetByEmail(claims.Email)
+	switch {
+	case err == nil:
+		err = app.models.Identities.Link(user, identity)
+	case errors.Is(err, data.ErrRecordNotFound):
+		user = &data.BaseUserAccount{
+			FirstName: claims.GivenName,
+			LastName:  claims.FamilyName,
+			Email:     claims.Email,
+		}
+
+		if user.FirstName == "" {
+			user.FirstName, user.LastName = oidcNames(claims)
+		}
+
+		err = app.models.Identities.CreateUser(user, identity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// oidcNames falls back to splitting the full name, or failing that the part of the email
+// address before the @, when the provider doesn't give the first and last names.
+func oidcNames(claims *oidc.Claims) (string, string) {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		return strings.SplitN(claims.Email, "@", 2)[0], ""
+	}
+
+	if i := strings.LastIndex(name, " "); i > 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return name, ""
+}
+
+// runOIDCStateCleanup periodically removes sign-ins that were started but never finished,
+// until done is closed.
+func (app *application) runOIDCStateCleanup(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := app.models.Identities.DeleteExpiredLoginStates()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
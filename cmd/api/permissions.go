@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"marketier/internal/data"
+	"marketier/internal/validator"
+	"net/http"
+	"strings"
+)
+
+// readPermissionGrantUser loads the user named in the URL for the permission grant
+// handlers, writing the error response itself when the user can't be found.
+func (app *application) readPermissionGrantUser(w http.ResponseWriter, r *http.Request) (*data.BaseUserAccount, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	user, err := app.models.BaseUsersModel.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return user, true
+}
+
+// readPermissionCodes reads the codes from the request body and checks that every one of
+// them is a known permission, returning them with an audit entry for the change.
+func (app *application) readPermissionCodes(w http.ResponseWriter, r *http.Request, user *data.BaseUserAccount, action string) ([]string, *data.AuditEntry, bool) {
+	var input struct {
+		Codes  []string `json:"codes"`
+		Reason string   `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	known, err := app.models.Permissions.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	v := validator.New()
+
+	entry := app.newAuditEntry(r, v, user, action, input.Reason)
+	entry.Details = strings.Join(input.Codes, ", ")
+
+	v.Check(len(input.Codes) > 0, "codes", "must contain at least 1 permission")
+	v.Check(validator.Unique(input.Codes), "codes", "must not contain duplicate values")
+
+	for _, code := range input.Codes {
+		v.Check(known.Include(code), "codes", "must only contain known permissions")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, nil, false
+	}
+
+	return input.Codes, entry, true
+}
+
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, user *data.BaseUserAccount) {
+	permissions, err := app.models.Permissions.GetAllForUser(user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	grants, err := app.models.Permissions.GetGrantsForUser(user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user_id": user.UserId, "permissions": permissions, "grants": grants}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readPermissionGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readPermissionGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	codes, entry, ok := app.readPermissionCodes(w, r, user, data.AdminActionGrantPermissions)
+	if !ok {
+		return
+	}
+
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventPermissionsChanged, "granted "+strings.Join(codes, ","))
+
+	err := app.models.AdminModel.GrantPermissions(user.UserId, codes, entry, event)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
+
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.readPermissionGrantUser(w, r)
+	if !ok {
+		return
+	}
+
+	codes, entry, ok := app.readPermissionCodes(w, r, user, data.AdminActionRevokePermissions)
+	if !ok {
+		return
+	}
+
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventPermissionsChanged, "revoked "+strings.Join(codes, ","))
+
+	err := app.models.AdminModel.RevokePermissions(user.UserId, codes, entry, event)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeUserPermissions(w, r, user)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"marketier/internal/data"
+	"marketier/internal/validator"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// profileImagePaths returns where the upload handlers save each size of a user's profile
+// image.
+func profileImagePaths(userId int64) []string {
+	paths := []string{}
+	for _, size := range []int{360, 180, 40} {
+		paths = append(paths, fmt.Sprintf("../internals/images/profile_images/profile_img_%d:%v.png", size, userId))
+	}
+	return paths
+}
+
+func proposalImagePaths(proposalId int64) []string {
+	paths := []string{}
+	for _, size := range []int{800, 400, 100} {
+		paths = append(paths, fmt.Sprintf("../internals/images/proposal_images/proposal_img_%d:%v.png", size, proposalId))
+	}
+	return paths
+}
+
+func productImagePaths(productId int64) []string {
+	paths := []string{}
+	for image := 1; image <= 3; image++ {
+		for _, size := range []int{800, 400, 100} {
+			paths = append(paths, fmt.Sprintf("../internals/images/product_images/product_img_%d_%d:%v.png", image, size, productId))
+		}
+	}
+	return paths
+}
+
+// requestDataExportHandler starts building an archive of everything held about the user.
+// It can take a while, so it is built in the background and the user is emailed a link to
+// download it when it's ready. Each user can only ask once per export interval.
+func (app *application) requestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	ok, err := app.models.DataExports.Claim(user.UserId, app.config.privacy.exportInterval)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !ok {
+		app.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	app.background(func() {
+		err := app.buildDataExport(user)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(user.UserId)})
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you with a link to download your data"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// buildDataExport writes the user's data to a ZIP archive, one JSON file for each part of
+// it along with their images, and emails them a token to download it.
+func (app *application) buildDataExport(user *data.BaseUserAccount) error {
+	userData, err := app.models.DataExports.Collect(user.UserId)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(app.config.privacy.exportDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(app.config.privacy.exportDir, "export-*.zip")
+	if err != nil {
+		return err
+	}
+
+	err = writeDataExport(file, user.UserId, userData)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	// Closing flushes the archive to disk, so an error here means it may be incomplete.
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	export := &data.DataExport{
+		UserId:   user.UserId,
+		FileName: filepath.Base(file.Name()),
+		Expiry:   time.Now().Add(app.config.privacy.exportTTL),
+	}
+
+	token, previous, err := app.models.DataExports.Insert(export)
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	if previous != "" {
+		app.removeFiles(filepath.Join(app.config.privacy.exportDir, previous))
+	}
+
+	data := map[string]interface{}{
+		"firstName":     user.FirstName,
+		"downloadToken": token.Plaintext,
+		"ttl":           app.config.privacy.exportTTL.String(),
+	}
+
+	return app.mailer.Send(user.Email, "data_export.tmpl", data)
+}
+
+func writeDataExport(w io.Writer, userId int64, userData *data.UserData) error {
+	zw := zip.NewWriter(w)
+
+	for _, section := range userData.Sections {
+		var js bytes.Buffer
+
+		err := json.Indent(&js, section.Rows, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		f, err := zw.Create(section.Name + ".json")
+		if err != nil {
+			return err
+		}
+
+		_, err = js.WriteTo(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	images := profileImagePaths(userId)
+	for _, id := range userData.ProposalIds {
+		images = append(images, proposalImagePaths(id)...)
+	}
+	for _, id := range userData.ProductIds {
+		images = append(images, productImagePaths(id)...)
+	}
+
+	for _, path := range images {
+		err := addFileToZip(zw, path, "images/"+filepath.Base(path))
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// addFileToZip copies the file into the archive under name. Images are optional, so a
+// file that doesn't exist is skipped.
+func addFileToZip(zw *zip.Writer, path, name string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer src.Close()
+
+	dst, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// downloadDataExportHandler sends the archive that the token in the URL was emailed for.
+func (app *application) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	token := httprouter.ParamsFromContext(r.Context()).ByName("token")
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	export, err := app.models.DataExports.GetForToken(token)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	file, err := os.Open(filepath.Join(app.config.privacy.exportDir, export.FileName))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"marketier-export-%s.zip\"", export.CreatedAt.Format("2006-01-02")))
+
+	http.ServeContent(w, r, "", export.CreatedAt, file)
+}
+
+// requestAccountDeletionHandler schedules the account named in the URL to be erased once
+// the grace period is over. Until then the account can't be used, but a user who asked
+// for their own account to be deleted can log in and call it off.
+func (app *application) requestAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user, err := app.models.BaseUsersModel.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	deletion := &data.AccountDeletion{
+		UserId:      user.UserId,
+		RequestedBy: app.contextGetUser(r).UserId,
+		DeleteAfter: time.Now().Add(app.config.privacy.deletionGracePeriod),
+	}
+
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventDeletionRequested, "")
+
+	err = app.models.AccountDeletions.Request(user, deletion, event)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.invalidTransitionResponse(w, r, user.AccountStatus, data.AccountStatusPendingDeletion)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.background(func() {
+		data := map[string]interface{}{
+			"firstName":     user.FirstName,
+			"deleteAfter":   deletion.DeleteAfter.Format("2 January 2006"),
+			"selfRequested": deletion.RequestedBy == user.UserId,
+		}
+
+		err := app.mailer.Send(user.Email, "account_deletion.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"deletion": deletion}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// cancelAccountDeletionHandler keeps an account that the user asked to have deleted.
+func (app *application) cancelAccountDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	event := app.newSecurityEvent(r, user.UserId, data.SecurityEventDeletionCancelled, "")
+
+	err := app.models.AccountDeletions.Cancel(user, event)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.invalidTransitionResponse(w, r, user.AccountStatus, data.AccountStatusActivated)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runAccountDeletions erases the accounts whose grace period is over, and removes data
+// exports that have expired.
+func (app *application) runAccountDeletions(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			app.eraseDueAccounts()
+
+			fileNames, err := app.models.DataExports.DeleteExpired()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+
+			for _, fileName := range fileNames {
+				app.removeFiles(filepath.Join(app.config.privacy.exportDir, fileName))
+			}
+		}
+	}
+}
+
+func (app *application) eraseDueAccounts() {
+	ids, err := app.models.AccountDeletions.GetDue()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+		return
+	}
+
+	for _, id := range ids {
+		erasure, err := app.models.AccountDeletions.Erase(id)
+		if err != nil {
+			// An account reactivated since GetDue ran is no longer due.
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.PrintError(err, map[string]string{"user_id": fmt.Sprint(id)})
+			}
+			continue
+		}
+
+		// The rows are gone, so the files are removed afterwards. A file that can't be
+		// removed is logged and left behind rather than holding up the other accounts.
+		files := profileImagePaths(erasure.UserId)
+		for _, proposalId := range erasure.ProposalIds {
+			files = append(files, proposalImagePaths(proposalId)...)
+		}
+		for _, productId := range erasure.ProductIds {
+			files = append(files, productImagePaths(productId)...)
+		}
+		if erasure.ExportFileName != "" {
+			files = append(files, filepath.Join(app.config.privacy.exportDir, erasure.ExportFileName))
+		}
+
+		app.removeFiles(files...)
+
+		app.logger.PrintInfo("account erased", map[string]string{"user_id": fmt.Sprint(id)})
+	}
+}
+
+// removeFiles deletes the files, ignoring any that are already gone.
+func (app *application) removeFiles(paths ...string) {
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
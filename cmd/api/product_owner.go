@@ -154,10 +154,6 @@ func (app *application) updateProductOwnersHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	if input.Email != nil {
-		user.BaseUserAccount.Email = *input.Email
-	}
-
 	if input.Address != nil {
 		user.BaseUserAccount.Address = *input.Address
 	}
@@ -174,8 +170,16 @@ func (app *application) updateProductOwnersHandler(w http.ResponseWriter, r *htt
 		user.About = *input.About
 	}
 
+	// The email address only changes once the new one has been confirmed, which is only
+	// asked for once the rest of the update has been saved.
+	emailChanged := input.Email != nil && *input.Email != user.BaseUserAccount.Email
+
 	v := validator.New()
 
+	if emailChanged {
+		data.ValidateEmail(v, *input.Email)
+	}
+
 	if data.ValidateProductOwnerUser(v, user); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -192,7 +196,20 @@ func (app *application) updateProductOwnersHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if emailChanged && !app.requestEmailChange(w, r, &user.BaseUserAccount, *input.Email) {
+		return
+	}
+
+	if input.Password != nil {
+		app.recordSecurityEvent(r, user.BaseUserAccount.UserId, data.SecurityEventPasswordChanged, "")
+	}
+
+	env := envelope{"user": user}
+	if emailChanged {
+		env["message"] = emailChangeMessage
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -205,6 +222,22 @@ func (app *application) uploadProductImagesHandler(w http.ResponseWriter, r *htt
 		return
 	}
 
+	product, err := app.models.ProductModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canModifyProduct(r, product) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	var input ParseFormData
 	input.FileNames = []string{"product_image_1", "product_image_2", "product_image_3"}
 	err = app.parseMultipartForm(w, r, &input)
@@ -20,8 +20,9 @@ func (app *application) registerProductHandler(w http.ResponseWriter, r *http.Re
 	}
 
 	product := &data.Product{
-		Name:  input.Name,
-		About: input.About,
+		OwnerId: app.contextGetUser(r).UserId,
+		Name:    input.Name,
+		About:   input.About,
 	}
 
 	v := validator.New()
@@ -67,6 +68,75 @@ func (app *application) showProductHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+func (app *application) listProductsHandler(w http.ResponseWriter, r *http.Request) {
+	app.listProducts(w, r, 0)
+}
+
+func (app *application) listProductOwnerProductsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.ProductOwnerModel.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.listProducts(w, r, id)
+}
+
+// listProducts writes a filtered page of products, limited to a single product owner's
+// catalogue when ownerId is non-zero.
+func (app *application) listProducts(w http.ResponseWriter, r *http.Request, ownerId int64) {
+	var input struct {
+		Search   string
+		MinStars int
+		MaxStars int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+	input.MinStars = app.readInt(qs, "min_stars", 0, v)
+	input.MaxStars = app.readInt(qs, "max_stars", 5, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "product_id")
+	input.Filters.SortSafelist = []string{"product_id", "name", "stars", "average_rating", "-product_id", "-name", "-stars", "-average_rating"}
+
+	v.Check(input.MinStars >= 0 && input.MinStars <= 5, "min_stars", "must be between 0 and 5")
+	v.Check(input.MaxStars >= 0 && input.MaxStars <= 5, "max_stars", "must be between 0 and 5")
+	v.Check(input.MinStars <= input.MaxStars, "min_stars", "must not be greater than max_stars")
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	products, metadata, err := app.models.ProductModel.GetAll(ownerId, input.Search, input.MinStars, input.MaxStars, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"products": products, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -85,6 +155,11 @@ func (app *application) updateProductHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !app.canModifyProduct(r, product) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	var input struct {
 		Name  *string `json:"name"`
 		About *string `json:"about"`
@@ -135,7 +210,7 @@ func (app *application) deleteProductsHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.models.ProductModel.Delete(id)
+	product, err := app.models.ProductModel.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -146,8 +221,30 @@ func (app *application) deleteProductsHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	if !app.canModifyProduct(r, product) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.ProductModel.Delete(product.ProductId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "product successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// canModifyProduct reports whether the current user owns the product or may manage every
+// product.
+func (app *application) canModifyProduct(r *http.Request, product *data.Product) bool {
+	return app.ownsOrPermitted(r, product.OwnerId, "products:manage")
+}
@@ -9,8 +9,9 @@ import (
 
 func (app *application) registerProposalHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Title string `json:"title"`
-		About string `json:"about"`
+		ProductId int64  `json:"product_id"`
+		Title     string `json:"title"`
+		About     string `json:"about"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -20,8 +21,10 @@ func (app *application) registerProposalHandler(w http.ResponseWriter, r *http.R
 	}
 
 	proposal := &data.Proposal{
-		Title: input.Title,
-		About: input.About,
+		MarketierId: app.contextGetUser(r).UserId,
+		ProductId:   input.ProductId,
+		Title:       input.Title,
+		About:       input.About,
 	}
 
 	v := validator.New()
@@ -31,9 +34,21 @@ func (app *application) registerProposalHandler(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	_, err = app.models.ProductModel.Get(proposal.ProductId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "no matching product found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
 	err = app.models.ProposalModel.Insert(proposal)
 	if err != nil {
-		app.failedValidationResponse(w, r, v.Errors)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
@@ -44,44 +59,30 @@ func (app *application) registerProposalHandler(w http.ResponseWriter, r *http.R
 }
 
 func (app *application) showProposalHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
+	proposal, product, ok := app.readProposal(w, r)
+	if !ok {
 		return
 	}
 
-	proposal, err := app.models.ProposalModel.Get(id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	if !app.isProposalParty(r, proposal, product) {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"proposal": proposal}, nil)
+	err := app.writeJSON(w, http.StatusOK, envelope{"proposal": proposal}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) updateProposalHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
+	proposal, _, ok := app.readProposal(w, r)
+	if !ok {
 		return
 	}
 
-	proposal, err := app.models.ProposalModel.Get(id)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+	if proposal.MarketierId != app.contextGetUser(r).UserId {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
@@ -90,7 +91,7 @@ func (app *application) updateProposalHandler(w http.ResponseWriter, r *http.Req
 		About *string `json:"about"`
 	}
 
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -106,6 +107,8 @@ func (app *application) updateProposalHandler(w http.ResponseWriter, r *http.Req
 
 	v := validator.New()
 
+	v.Check(proposal.Status == data.ProposalStatusDraft, "status", "only draft proposals can be edited")
+
 	if data.ValidateProposal(v, proposal); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -129,13 +132,17 @@ func (app *application) updateProposalHandler(w http.ResponseWriter, r *http.Req
 }
 
 func (app *application) deleteProposalHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
+	proposal, _, ok := app.readProposal(w, r)
+	if !ok {
 		return
 	}
 
-	err = app.models.ProposalModel.Delete(id)
+	if !app.ownsOrPermitted(r, proposal.MarketierId, "proposals:manage") {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.models.ProposalModel.Delete(proposal.ProposalId)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -146,8 +153,164 @@ func (app *application) deleteProposalHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "proposal successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) submitProposalHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeProposalTransition(w, r, data.ProposalStatusSubmitted, isProposalMarketier)
+}
+
+func (app *application) withdrawProposalHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeProposalTransition(w, r, data.ProposalStatusWithdrawn, isProposalMarketier)
+}
+
+func (app *application) acceptProposalHandler(w http.ResponseWriter, r *http.Request) {
+	var link *data.TrackingLink
+
+	// Accepting the proposal and creating its tracking link happen together, so that an
+	// accepted proposal always has a link.
+	accept := func(proposal *data.Proposal, toStatus string, actorId int64) error {
+		var err error
+		link, err = app.models.ProposalModel.Accept(proposal, actorId)
+		return err
+	}
+
+	proposal, ok := app.transitionProposal(w, r, data.ProposalStatusAccepted, isProposalProductOwner, accept)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"proposal": proposal, "tracking_link": link}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) rejectProposalHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeProposalTransition(w, r, data.ProposalStatusRejected, isProposalProductOwner)
+}
+
+func (app *application) completeProposalHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeProposalTransition(w, r, data.ProposalStatusCompleted, isProposalProductOwner)
+}
+
+func (app *application) showProposalHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	proposal, product, ok := app.readProposal(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.isProposalParty(r, proposal, product) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	history, err := app.models.ProposalModel.GetHistory(proposal.ProposalId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) writeProposalTransition(w http.ResponseWriter, r *http.Request, toStatus string, mayAct func(*data.BaseUserAccount, *data.Proposal, *data.Product) bool) {
+	proposal, ok := app.transitionProposal(w, r, toStatus, mayAct, app.models.ProposalModel.Transition)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"proposal": proposal}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// transitionProposal moves the proposal named in the URL to toStatus with apply, provided
+// that mayAct allows the current user to make the change. On failure the error response
+// has already been written.
+func (app *application) transitionProposal(w http.ResponseWriter, r *http.Request, toStatus string, mayAct func(*data.BaseUserAccount, *data.Proposal, *data.Product) bool, apply func(*data.Proposal, string, int64) error) (*data.Proposal, bool) {
+	proposal, product, ok := app.readProposal(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+
+	if !mayAct(user, proposal, product) {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	fromStatus := proposal.Status
+
+	err := apply(proposal, toStatus, user.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidTransition):
+			app.invalidTransitionResponse(w, r, fromStatus, toStatus)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return proposal, true
+}
+
+// readProposal loads the proposal named in the URL along with the product it targets,
+// writing the error response itself when either cannot be found.
+func (app *application) readProposal(w http.ResponseWriter, r *http.Request) (*data.Proposal, *data.Product, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, nil, false
+	}
+
+	proposal, err := app.models.ProposalModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	product, err := app.models.ProductModel.Get(proposal.ProductId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	return proposal, product, true
+}
+
+func isProposalMarketier(user *data.BaseUserAccount, proposal *data.Proposal, product *data.Product) bool {
+	return proposal.MarketierId == user.UserId
+}
+
+func isProposalProductOwner(user *data.BaseUserAccount, proposal *data.Proposal, product *data.Product) bool {
+	return product.OwnerId == user.UserId
+}
+
+// isProposalParty reports whether the current user is the proposal's marketier, the
+// owner of its product, or may manage every proposal.
+func (app *application) isProposalParty(r *http.Request, proposal *data.Proposal, product *data.Product) bool {
+	user := app.contextGetUser(r)
+	return app.contextGetPermissions(r).Include("proposals:manage") || isProposalMarketier(user, proposal, product) || isProposalProductOwner(user, proposal, product)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"marketier/internal/data"
+	"marketier/internal/moderation"
+	"marketier/internal/validator"
+	"net/http"
+)
+
+// moderateReview runs the review through the moderation rules and sets its status. Clean
+// reviews are approved straight away, the rest wait in the queue for an admin.
+func (app *application) moderateReview(review *data.Review) error {
+	flags := app.moderator.Check(review.Title, review.About)
+
+	duplicate, err := app.models.ReviewModel.HasDuplicateText(review.UserId, review.ReviewId, review.About)
+	if err != nil {
+		return err
+	}
+
+	if duplicate && !validator.In(moderation.FlagRepeatedText, flags...) {
+		flags = append(flags, moderation.FlagRepeatedText)
+	}
+
+	review.Flags = flags
+
+	if len(flags) == 0 {
+		review.Status = data.ReviewStatusApproved
+	} else {
+		review.Status = data.ReviewStatusPending
+	}
+
+	return nil
+}
+
+func (app *application) listReviewQueueHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "created_at")
+	input.Filters.SortSafelist = []string{"created_at", "rating", "-created_at", "-rating"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := app.models.ReviewModel.GetQueue(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) approveReviewHandler(w http.ResponseWriter, r *http.Request) {
+	app.decideReview(w, r, data.ReviewStatusApproved, "")
+}
+
+func (app *application) rejectReviewHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateRejectionReason(v, input.Reason); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	app.decideReview(w, r, data.ReviewStatusRejected, input.Reason)
+}
+
+// decideReview applies an admin's decision to the pending review named in the URL and
+// emails the outcome to the reviewer.
+func (app *application) decideReview(w http.ResponseWriter, r *http.Request, status, reason string) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := app.models.ReviewModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if review.Status != data.ReviewStatusPending {
+		app.invalidTransitionResponse(w, r, review.Status, status)
+		return
+	}
+
+	err = app.models.ReviewModel.Moderate(review, status, reason, app.contextGetUser(r).UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviewer, err := app.models.BaseUsersModel.GetById(review.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	product, err := app.models.ProductModel.Get(review.ProductId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	emailData := map[string]interface{}{
+		"firstName":   reviewer.FirstName,
+		"productName": product.Name,
+		"title":       review.Title,
+		"approved":    review.Status == data.ReviewStatusApproved,
+		"reason":      review.RejectionReason,
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(reviewer.Email, "review_moderated.tmpl", emailData)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -9,9 +9,10 @@ import (
 
 func (app *application) registerReviewHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		UserId int64  `json:"user_id"`
-		Title  string `json:"title"`
-		About  string `json:"about"`
+		ProductId int64  `json:"product_id"`
+		Rating    int8   `json:"rating"`
+		Title     string `json:"title"`
+		About     string `json:"about"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -21,9 +22,11 @@ func (app *application) registerReviewHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	review := &data.Review{
-		UserId: input.UserId,
-		Title:  input.Title,
-		About:  input.About,
+		UserId:    app.contextGetUser(r).UserId,
+		ProductId: input.ProductId,
+		Rating:    input.Rating,
+		Title:     input.Title,
+		About:     input.About,
 	}
 
 	v := validator.New()
@@ -33,9 +36,33 @@ func (app *application) registerReviewHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	_, err = app.models.ProductModel.Get(review.ProductId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("product_id", "no matching product found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.moderateReview(review)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.models.ReviewModel.Insert(review)
 	if err != nil {
-		app.failedValidationResponse(w, r, v.Errors)
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("product_id", "you have already reviewed this product")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -63,12 +90,66 @@ func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Reviews that haven't been approved are only visible to their author and to admins.
+	if review.Status != data.ReviewStatusApproved && !app.ownsOrPermitted(r, review.UserId, "reviews:moderate") {
+		app.notFoundResponse(w, r)
+		return
+	}
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+func (app *application) listProductReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"created_at", "rating", "-created_at", "-rating"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.ProductModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	reviews, metadata, err := app.models.ReviewModel.GetAllForProduct(id, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
@@ -87,9 +168,15 @@ func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if review.UserId != app.contextGetUser(r).UserId {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
 	var input struct {
-		Title *string `json:"title"`
-		About *string `json:"about"`
+		Rating *int8   `json:"rating"`
+		Title  *string `json:"title"`
+		About  *string `json:"about"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -98,6 +185,10 @@ func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+
 	if input.Title != nil {
 		review.Title = *input.Title
 	}
@@ -113,6 +204,14 @@ func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	// Edited reviews go through moderation again, so an approved review can't be changed
+	// into something that would have been held back.
+	err = app.moderateReview(review)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	err = app.models.ReviewModel.Update(review)
 	if err != nil {
 		switch {
@@ -124,7 +223,7 @@ func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"reiew": review}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"review": review}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -137,7 +236,23 @@ func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.ReviewModel.Delete(id)
+	review, err := app.models.ReviewModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.ownsOrPermitted(r, review.UserId, "reviews:moderate") {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.models.ReviewModel.Delete(review)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
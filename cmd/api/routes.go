@@ -17,50 +17,139 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodPost, "/v1/users/marketiers", app.registerMarketierHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/users/product_owners", app.registerProductOwnerHandler)
 
-	router.HandlerFunc(http.MethodGet, "/v1/users/shoppers/:id", app.requirePermission([]int8{0, 3, 4}, app.showShopper)) //other shoppers and general public can't view other shoppers
+	router.HandlerFunc(http.MethodGet, "/v1/users/shoppers/:id", app.requireOwnership("users:read", app.showShopper)) //other shoppers and general public can't view other shoppers
 	router.HandlerFunc(http.MethodGet, "/v1/users/marketiers/:id", app.showMarketier)
 	router.HandlerFunc(http.MethodGet, "/v1/users/product_owners/:id", app.showProductOwner)
+	router.HandlerFunc(http.MethodGet, "/v1/users/product_owners/:id/products", app.listProductOwnerProductsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/marketiers/:id/balance", app.requireOwnership("ledger:read", app.showMarketierBalanceHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/marketiers/:id/statement", app.requireOwnership("ledger:read", app.showMarketierStatementHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/marketiers/:id/tiers", app.requireOwnership("tiers:read", app.showMarketierTierHistoryHandler))
 
-	router.HandlerFunc(http.MethodPatch, "v1/users/shoppers/:id", app.requirePermission([]int8{0, 4}, app.updateShoppersHandler))
-	router.HandlerFunc(http.MethodPatch, "v1/users/marketiers/:id", app.requirePermission([]int8{0, 4}, app.updateMarketiersHandler))
-	router.HandlerFunc(http.MethodPatch, "v1/users/product_owners/:id", app.requirePermission([]int8{0, 4}, app.updateProductOwnersHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/shoppers/:id", app.requireOwnership("users:write", app.updateShoppersHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/marketiers/:id", app.requireOwnership("users:write", app.updateMarketiersHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/product_owners/:id", app.requireOwnership("users:write", app.updateProductOwnersHandler))
 
-	router.HandlerFunc(http.MethodDelete, "v1/users/shoppers/:id", app.requirePermission([]int8{0, 4}, app.deleteBaseUserHandler))
-	router.HandlerFunc(http.MethodDelete, "v1/users/marketiers/:id", app.requirePermission([]int8{0, 4}, app.deleteBaseUserHandler))
-	router.HandlerFunc(http.MethodDelete, "v1/users/product_owners/:id", app.requirePermission([]int8{0, 4}, app.deleteBaseUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/shoppers/:id", app.requireOwnership("users:write", app.requestAccountDeletionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/marketiers/:id", app.requireOwnership("users:write", app.requestAccountDeletionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/product_owners/:id", app.requireOwnership("users:write", app.requestAccountDeletionHandler))
 
 	//image uploads
-	router.HandlerFunc(http.MethodPut, "/v1/users/:id/profile_img", app.requirePermission([]int8{0, 4}, app.uploadProfileImageHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/products/:id/images", app.requirePermission([]int8{0, 4}, app.uploadProductImagesHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/proposals/:id/image", app.requirePermission([]int8{0, 4}, app.uploadProposalImageHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/shoppers/:id/profile_img", app.requireOwnership("users:write", app.uploadProfileImageHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/marketiers/:id/profile_img", app.requireOwnership("users:write", app.uploadProfileImageHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/product_owners/:id/profile_img", app.requireOwnership("users:write", app.uploadProfileImageHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/products/:id/images", app.requirePermission("products:write", app.uploadProductImagesHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/proposals/:id/image", app.requirePermission("proposals:write", app.uploadProposalImageHandler))
 
 	//product -> get, post, patch, delete (id, name, about, stars)
+	router.HandlerFunc(http.MethodGet, "/v1/products", app.listProductsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/products/:id", app.showProductHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/products", app.registerProductHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/products/:id", app.updateProductHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/products/:id", app.deleteProductsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/products", app.requirePermission("products:write", app.registerProductHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/products/:id", app.requirePermission("products:write", app.updateProductHandler)) //owner check happens in the handler
+	router.HandlerFunc(http.MethodDelete, "/v1/products/:id", app.requirePermission("products:write", app.deleteProductsHandler))
 
 	//proposal -> get, post, patch, delete (id, title, method, about)
-	router.HandlerFunc(http.MethodGet, "/v1/proposal/:id", app.showProposalHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/proposal", app.registerProposalHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/proposal/:id", app.updateProposalHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/proposal/:id", app.deleteProposalHandler)
-
-	//contact -> get, post, patch, delete (name, email, about)
-	router.HandlerFunc(http.MethodGet, "/v1/contact/:id", app.showContactHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/contact", app.registerContactHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/contact/:id", app.updateContactHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/contact/:id", app.deleteContactHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/proposal/:id", app.requireActivatedUser(app.showProposalHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/proposal", app.requirePermission("proposals:write", app.registerProposalHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/proposal/:id", app.requirePermission("proposals:write", app.updateProposalHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/proposal/:id", app.requirePermission("proposals:write", app.deleteProposalHandler))
+
+	//proposal lifecycle -> marketier submits or withdraws, product owner accepts, rejects or completes
+	router.HandlerFunc(http.MethodPost, "/v1/proposals/:id/submit", app.requirePermission("proposals:write", app.submitProposalHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/proposals/:id/withdraw", app.requirePermission("proposals:write", app.withdrawProposalHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/proposals/:id/accept", app.requirePermission("proposals:respond", app.acceptProposalHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/proposals/:id/reject", app.requirePermission("proposals:respond", app.rejectProposalHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/proposals/:id/complete", app.requirePermission("proposals:respond", app.completeProposalHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/proposals/:id/history", app.requireActivatedUser(app.showProposalHistoryHandler))
+
+	//threads -> conversations between users, only visible to their participants
+	router.HandlerFunc(http.MethodGet, "/v1/threads", app.requireActivatedUser(app.listThreadsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/threads", app.requirePermission("threads:write", app.createThreadHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/threads/:id/messages", app.requireActivatedUser(app.listThreadMessagesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/threads/:id/messages", app.requirePermission("threads:write", app.createMessageHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/threads/:id/read", app.requireActivatedUser(app.markThreadReadHandler))
 
 	//reviews -> get, post, put, delete
 	router.HandlerFunc(http.MethodGet, "/v1/review/:id", app.showReviewHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/review", app.registerReviewHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/review/:id", app.updateReviewHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/review/:id", app.deleteReviewHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/review", app.requirePermission("reviews:write", app.registerReviewHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/review/:id", app.requirePermission("reviews:write", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/review/:id", app.requirePermission("reviews:write", app.deleteReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/products/:id/reviews", app.listProductReviewsHandler)
+
+	//review moderation -> admins work through the reviews the moderation rules held back
+	router.HandlerFunc(http.MethodGet, "/v1/admin/reviews", app.requireAdminPermission("reviews:moderate", app.listReviewQueueHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/reviews/:id/approve", app.requireAdminPermission("reviews:moderate", app.approveReviewHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/reviews/:id/reject", app.requireAdminPermission("reviews:moderate", app.rejectReviewHandler))
+
+	//permissions -> grants on top of the permissions a user's role already gives them
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requireAdminPermission("permissions:write", app.showUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requireAdminPermission("permissions:write", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requireAdminPermission("permissions:write", app.revokeUserPermissionsHandler))
+
+	//user administration -> every action is written to the audit trail with the acting admin and a reason
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requireAdminPermission("users:manage", app.listUsersAdminHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requireAdminPermission("users:manage", app.showUserAdminHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/suspend", app.requireAdminPermission("users:manage", app.suspendUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/reinstate", app.requireAdminPermission("users:manage", app.reinstateUserHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/password-reset", app.requireAdminPermission("users:manage", app.forcePasswordResetHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/revoke-tokens", app.requireAdminPermission("users:manage", app.revokeUserTokensHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/account-type", app.requireAdminPermission("users:manage", app.changeAccountTypeHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", app.requireAdminPermission("users:manage", app.listAuditTrailHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/security_events", app.requireAdminPermission("users:manage", app.listSecurityEventsHandler))
+
+	//affiliate tracking -> public click redirect, click counts for the marketier and product owner
+	router.HandlerFunc(http.MethodGet, "/r/:code", app.redirectTrackingLinkHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/tracking_links", app.requirePermission("tracking:read", app.listTrackingLinksHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/tracking_links/:id", app.requirePermission("tracking:read", app.showTrackingLinkHandler))
+
+	//conversions -> product owners report sales, attributed to a tracking click
+	router.HandlerFunc(http.MethodPost, "/v1/conversions", app.requirePermission("conversions:write", app.registerConversionHandler))
 
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateBaseUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateBaseUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email/cancel", app.cancelEmailChangeHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/unlocked", app.unlockAccountHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/reactivated", app.reactivateAccountHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/login_reported", app.reportLoginHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link", app.createMagicLinkTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/magic-link/exchange", app.exchangeMagicLinkTokenHandler)
+
+	//social login -> start at a provider, which sends the user back to its callback
+	router.HandlerFunc(http.MethodGet, "/v1/auth/oidc", app.listOIDCProvidersHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/auth/oidc/:provider", app.startOIDCLoginHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/auth/oidc/:provider/callback", app.oidcCallbackHandler)
+
+	//account status -> users can deactivate their own account, and reactivate it by logging in again
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/deactivated", app.requireActivatedUser(app.deactivateAccountHandler))
+
+	//privacy -> users can download a copy of their data, and call off a deletion they asked for during the grace period
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/export", app.requireActivatedUser(app.requestDataExportHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/exports/:token", app.downloadDataExportHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/deletion", app.requireAuthenticatedUser(app.cancelAccountDeletionHandler))
+
+	//sessions -> the user's logins, each a family of refresh and authentication tokens, which can be revoked one at a time or all at once
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.deleteAllSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireAuthenticatedUser(app.deleteSessionHandler))
+
+	//security events -> an append-only log of logins, token requests and account changes, which users can read for their own account
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/security_events", app.requireAuthenticatedUser(app.listOwnSecurityEventsHandler))
+
+	//two-factor authentication -> enrol, confirm with a code to turn it on, and disable
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/mfa", app.requirePermission("mfa:write", app.showMFAHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/mfa", app.requirePermission("mfa:write", app.enrolMFAHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/mfa/confirm", app.requirePermission("mfa:write", app.confirmMFAHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/mfa", app.requirePermission("mfa:write", app.disableMFAHandler))
+
+	//API keys -> for the user's own backends, acting as the user with only the scopes given to the key
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api_keys", app.requirePermission("api_keys:write", app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api_keys", app.requirePermission("api_keys:write", app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api_keys/:id", app.requirePermission("api_keys:write", app.deleteAPIKeyHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
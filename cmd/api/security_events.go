@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+
+	"marketier/internal/data"
+	"marketier/internal/validator"
+
+	"github.com/tomasen/realip"
+)
+
+// newSecurityEvent describes an event about the user, with the address and user agent the
+// request came from. The current user is recorded as the actor when they aren't the user
+// the event is about. Actions that change an account pass the event to the data layer, to
+// be written in the same transaction as the change.
+func (app *application) newSecurityEvent(r *http.Request, userID int64, eventType, details string) *data.SecurityEvent {
+	event := &data.SecurityEvent{
+		UserId:    userID,
+		Type:      eventType,
+		IP:        realip.FromRequest(r),
+		UserAgent: r.UserAgent(),
+		Details:   details,
+	}
+
+	if actor := app.contextGetUser(r); !actor.IsAnonymous() && actor.UserId != userID {
+		event.ActorId = actor.UserId
+	}
+
+	return event
+}
+
+// recordSecurityEvent adds an event about the user to the security log on its own, for
+// events that aren't part of a change to the account. Failing to record an event is
+// logged rather than failing the request.
+func (app *application) recordSecurityEvent(r *http.Request, userID int64, eventType, details string) {
+	err := app.models.SecurityEvents.Record(app.newSecurityEvent(r, userID, eventType, details))
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// listOwnSecurityEventsHandler lets users see what has happened to their own account.
+func (app *application) listOwnSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, filters, ok := app.readSecurityEventFilter(w, r)
+	if !ok {
+		return
+	}
+
+	// Users only ever see their own events, whatever user_id says.
+	filter.UserId = app.contextGetUser(r).UserId
+
+	app.writeSecurityEvents(w, r, filter, filters)
+}
+
+// listSecurityEventsHandler lets administrators search the whole log.
+func (app *application) listSecurityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, filters, ok := app.readSecurityEventFilter(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeSecurityEvents(w, r, filter, filters)
+}
+
+// readSecurityEventFilter reads the user, type and time range filters and paging from the
+// query string. The range is given as from and to RFC 3339 timestamps.
+func (app *application) readSecurityEventFilter(w http.ResponseWriter, r *http.Request) (data.SecurityEventFilter, data.Filters, bool) {
+	var filter data.SecurityEventFilter
+	var filters data.Filters
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	filter.UserId = int64(app.readInt(qs, "user_id", 0, v))
+	filter.Type = app.readString(qs, "type", "")
+	filter.From = app.readTime(qs, "from", v)
+	filter.To = app.readTime(qs, "to", v)
+
+	filters.Page = app.readInt(qs, "page", 1, v)
+	filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	filters.Sort = app.readString(qs, "sort", "-created_at")
+	filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	v.Check(filter.Type == "" || validator.In(filter.Type, data.SecurityEventTypes...), "type", "must be a known event type")
+	v.Check(filter.From.IsZero() || filter.To.IsZero() || filter.From.Before(filter.To), "to", "must be after from")
+
+	if data.ValidateFilters(v, filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return filter, filters, false
+	}
+
+	return filter, filters, true
+}
+
+func (app *application) writeSecurityEvents(w http.ResponseWriter, r *http.Request, filter data.SecurityEventFilter, filters data.Filters) {
+	events, metadata, err := app.models.SecurityEvents.Search(filter, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"security_events": events, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -22,6 +22,36 @@ func (app *application) serve() error {
 
 	shutdownError := make(chan error)
 
+	// Closing stopJobs tells the periodic background jobs to finish up, so that the
+	// wait group below doesn't block shutdown forever.
+	stopJobs := make(chan struct{})
+
+	app.background(func() {
+		app.runTierRecalculation(stopJobs)
+	})
+
+	app.background(func() {
+		app.runUnreadMessageNotifications(stopJobs)
+	})
+
+	app.background(func() {
+		app.runLoginAttemptCleanup(stopJobs)
+	})
+
+	app.background(func() {
+		app.runOIDCStateCleanup(stopJobs)
+	})
+
+	app.background(func() {
+		app.runAccountDeletions(stopJobs)
+	})
+
+	if app.config.encryption.reencrypt {
+		app.background(func() {
+			app.runReencryption(stopJobs)
+		})
+	}
+
 	go func() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -47,6 +77,8 @@ func (app *application) serve() error {
 			"addr": srv.Addr,
 		})
 
+		close(stopJobs)
+
 		app.wg.Wait()
 		shutdownError <- nil
 	}()
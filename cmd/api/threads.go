@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"marketier/internal/data"
+	"marketier/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (app *application) createThreadHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Subject        string  `json:"subject"`
+		ParticipantIds []int64 `json:"participant_ids"`
+		Body           string  `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	thread := &data.Thread{
+		Subject:      input.Subject,
+		CreatedBy:    user.UserId,
+		Participants: []*data.Participant{{UserId: user.UserId}},
+	}
+
+	// The creator is always a participant, so drop them and any repeats from the list.
+	seen := map[int64]bool{user.UserId: true}
+	for _, id := range input.ParticipantIds {
+		if !seen[id] {
+			seen[id] = true
+			thread.Participants = append(thread.Participants, &data.Participant{UserId: id})
+		}
+	}
+
+	message := &data.Message{
+		SenderId: user.UserId,
+		Body:     input.Body,
+	}
+
+	v := validator.New()
+
+	data.ValidateThread(v, thread)
+	data.ValidateMessage(v, message)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ThreadModel.Insert(thread, message)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownParticipant):
+			v.AddError("participant_ids", "must only contain existing users")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"thread": thread, "message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UnreadOnly bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.UnreadOnly = app.readString(qs, "unread", "false") == "true"
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-last_message_at")
+	input.Filters.SortSafelist = []string{"last_message_at", "created_at", "unread_count", "-last_message_at", "-created_at", "-unread_count"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	threads, metadata, err := app.models.ThreadModel.GetAllForUser(app.contextGetUser(r).UserId, input.UnreadOnly, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"threads": threads, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readThread loads the thread named in the URL for the current user, writing a not found
+// response if it doesn't exist or the user isn't one of its participants.
+func (app *application) readThread(w http.ResponseWriter, r *http.Request) (*data.Thread, bool) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	thread, err := app.models.ThreadModel.GetForUser(id, app.contextGetUser(r).UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return thread, true
+}
+
+func (app *application) listThreadMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	thread, ok := app.readThread(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 50, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"created_at", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	messages, metadata, err := app.models.ThreadModel.GetMessages(thread.ThreadId, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"thread": thread, "messages": messages, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createMessageHandler(w http.ResponseWriter, r *http.Request) {
+	thread, ok := app.readThread(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	message := &data.Message{
+		ThreadId: thread.ThreadId,
+		SenderId: app.contextGetUser(r).UserId,
+		Body:     input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateMessage(v, message); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.ThreadModel.InsertMessage(message)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"message": message}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) markThreadReadHandler(w http.ResponseWriter, r *http.Request) {
+	thread, ok := app.readThread(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err := app.models.ThreadModel.MarkRead(thread.ThreadId, user.UserId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	thread, err = app.models.ThreadModel.GetForUser(thread.ThreadId, user.UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"thread": thread}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// runUnreadMessageNotifications emails participants about their unread messages once per
+// configured interval until done is closed. Each run sends at most one email per user,
+// covering every thread with messages they haven't been told about yet.
+func (app *application) runUnreadMessageNotifications(done <-chan struct{}) {
+	ticker := time.NewTicker(app.config.messages.notifyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := app.notifyUnreadMessages()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
+
+func (app *application) notifyUnreadMessages() error {
+	notices, err := app.models.ThreadModel.GetUnreadNotices()
+	if err != nil {
+		return err
+	}
+
+	// Notices come back ordered by user, so group each user's threads into one email.
+	byUser := make(map[int64][]*data.UnreadNotice)
+	order := []int64{}
+
+	for _, notice := range notices {
+		if _, exists := byUser[notice.UserId]; !exists {
+			order = append(order, notice.UserId)
+		}
+		byUser[notice.UserId] = append(byUser[notice.UserId], notice)
+	}
+
+	for _, userId := range order {
+		userNotices := byUser[userId]
+
+		// Record the notification before sending, so a slow or failed send can't cause the
+		// same messages to be emailed twice.
+		for _, notice := range userNotices {
+			err = app.models.ThreadModel.MarkNotified(notice.ThreadId, notice.UserId, notice.LatestMessageId)
+			if err != nil {
+				return err
+			}
+		}
+
+		recipient := userNotices[0].Email
+		emailData := map[string]interface{}{
+			"firstName": userNotices[0].FirstName,
+			"threads":   userNotices,
+		}
+
+		app.background(func() {
+			err := app.mailer.Send(recipient, "unread_messages.tmpl", emailData)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	app.logger.PrintInfo("sent unread message notifications", map[string]string{
+		"users": strconv.Itoa(len(order)),
+	})
+
+	return nil
+}
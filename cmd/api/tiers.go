@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"marketier/internal/data"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// runTierRecalculation re-evaluates every marketier's tier once per configured interval
+// until done is closed.
+func (app *application) runTierRecalculation(done <-chan struct{}) {
+	ticker := time.NewTicker(app.config.tiers.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			err := app.recalculateTiers()
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		}
+	}
+}
+
+func (app *application) recalculateTiers() error {
+	marketiers, err := app.models.MarketierUserModel.GetAll()
+	if err != nil {
+		return err
+	}
+
+	ratings, err := app.models.TierModel.AverageRatings()
+	if err != nil {
+		return err
+	}
+
+	changed := 0
+
+	for _, marketier := range marketiers {
+		accountAge := time.Since(marketier.BaseUserAccount.AccountCreationTime)
+		newTier := app.config.tiers.policy.Evaluate(marketier.SalesGenerated, ratings[marketier.BaseUserAccount.UserId], accountAge)
+
+		if newTier == marketier.Tier {
+			continue
+		}
+
+		change := &data.TierChange{
+			MarketierId: marketier.BaseUserAccount.UserId,
+			OldTier:     marketier.Tier,
+			NewTier:     newTier,
+		}
+
+		// An edit conflict means the marketier's tier changed since we read them. They'll
+		// be picked up again on the next run, so skip them for now.
+		err = app.models.TierModel.ChangeTier(change)
+		if err != nil {
+			if errors.Is(err, data.ErrEditConflict) {
+				continue
+			}
+			return err
+		}
+
+		changed++
+
+		recipient := marketier.BaseUserAccount.Email
+		emailData := map[string]interface{}{
+			"displayName": marketier.DisplayName,
+			"userID":      change.MarketierId,
+			"oldTier":     change.OldTier,
+			"newTier":     change.NewTier,
+		}
+
+		app.background(func() {
+			err := app.mailer.Send(recipient, "tier_changed.tmpl", emailData)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	app.logger.PrintInfo("recalculated marketier tiers", map[string]string{
+		"marketiers": strconv.Itoa(len(marketiers)),
+		"changed":    strconv.Itoa(changed),
+	})
+
+	return nil
+}
+
+func (app *application) showMarketierTierHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	marketier, err := app.models.MarketierUserModel.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	history, err := app.models.TierModel.GetHistory(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tier": marketier.Tier, "history": history}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"marketier/internal/data"
 	"marketier/internal/validator"
+
+	"github.com/tomasen/realip"
 )
 
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -31,36 +34,117 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.BaseUsersModel.GetByEmail(input.Email)
+	user, ok := app.checkCredentials(w, r, input.Email, input.Password)
+	if !ok {
+		return
+	}
+
+	app.completeLogin(w, r, user)
+}
+
+// checkCredentials looks up the user with the email address and checks their password,
+// counting a mismatch towards locking the address out. It writes an error response and
+// returns false if the user can't be logged in.
+func (app *application) checkCredentials(w http.ResponseWriter, r *http.Request, email, password string) (*data.BaseUserAccount, bool) {
+	if !app.checkLoginAllowed(w, r, email) {
+		return nil, false
+	}
+
+	user, err := app.models.BaseUsersModel.GetByEmail(email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			data.SpendPasswordCheck(password)
+
+			err = app.recordLoginFailure(r, email, nil)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return nil, false
+			}
+
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
-		return
+		return nil, false
 	}
 
-	match, err := user.Password.Matches(input.Password)
+	match, err := user.Password.Matches(password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
+		return nil, false
 	}
 
 	if !match {
+		err = app.recordLoginFailure(r, email, user)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+
 		app.invalidCredentialsResponse(w, r)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// completeLogin finishes logging in a user who has proven who they are. Accounts with
+// two-factor authentication turned on get a short-lived token instead, which is swapped
+// for an authentication token once the code checks out. Suspended and deactivated accounts
+// are turned away, since their tokens wouldn't be accepted.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *data.BaseUserAccount) {
+	if !app.checkAccountStatus(w, r, user) {
+		return
+	}
+
+	mfa, err := app.models.MFA.Get(user.UserId)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if mfa != nil && mfa.Enabled() {
+		token, err := app.models.Tokens.New(user.UserId, 5*time.Minute, data.ScopeMFAPending)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		env := envelope{"mfa_token": token, "message": "enter the code from your authenticator app to finish logging in"}
+
+		err = app.writeJSON(w, http.StatusAccepted, env, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.startSession(w, r, user)
+}
+
+// startSession logs the user in on a new session and sends them its tokens. Failed logins
+// are only forgotten here, once every step of logging in has passed, so that a correct
+// password doesn't wipe the count for someone still guessing the two-factor code.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *data.BaseUserAccount) {
+	token, refreshToken, err := app.models.Tokens.NewSession(user.UserId, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, realip.FromRequest(r), r.UserAgent())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.UserId, 24*time.Hour, data.ScopeAuthentication) //new token times out in 24 hours.
+	err = app.models.LoginAttempts.Reset(user.Email)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.recordSecurityEvent(r, user.UserId, data.SecurityEventLoginSucceeded, fmt.Sprintf("session %d", token.FamilyID))
+	app.notifyNewDevice(r, user)
+
 	//update last login time
-	*user.LastLoginTime = time.Now()
+	now := time.Now()
+	user.LastLoginTime = &now
 	err = app.models.BaseUsersModel.Update(user)
 	if err != nil {
 		switch {
@@ -72,7 +156,47 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil) //user receives authentication token to place in the request header.
+	//user receives authentication token to place in the request header, and a refresh token to get a new one when it expires.
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshTokenHandler exchanges a refresh token for a new authentication and refresh token
+// pair. The refresh token presented can't be used again.
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, refreshToken, err := app.models.Tokens.Rotate(input.RefreshToken, app.config.tokens.accessTTL, app.config.tokens.refreshTTL)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidRefreshTokenResponse(w, r)
+		case errors.Is(err, data.ErrRefreshTokenReused):
+			app.refreshTokenReusedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refreshToken}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -108,7 +232,7 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
-	if user.AccountStatus != "ACTIVATED" {
+	if user.AccountStatus != data.AccountStatusActivated {
 		v.AddError("email", "user account must be activated")
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -120,6 +244,8 @@ func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r
 		return
 	}
 
+	app.recordSecurityEvent(r, user.UserId, data.SecurityEventTokenCreated, data.ScopePasswordReset)
+
 	app.background(func() {
 		data := map[string]interface{}{
 			"passwordResetToken": token.Plaintext,
@@ -169,7 +295,7 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	if user.AccountStatus == "ACTIVATED" {
+	if user.AccountStatus != data.AccountStatusRegistering {
 		v.AddError("email", "user has already been activated")
 		app.failedValidationResponse(w, r, v.Errors)
 		return
@@ -181,6 +307,8 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		return
 	}
 
+	app.recordSecurityEvent(r, user.UserId, data.SecurityEventTokenCreated, data.ScopeActivation)
+
 	app.background(func() {
 		data := map[string]interface{}{
 			"activationToken": token.Plaintext,
@@ -199,3 +327,73 @@ func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *h
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// deleteAuthenticationTokenHandler logs out the session the request was made with.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.DeleteSession(app.contextGetUser(r).UserId, app.contextGetSessionID(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.models.Tokens.GetSessions(app.contextGetUser(r).UserId, app.contextGetSessionID(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Tokens.DeleteSession(app.contextGetUser(r).UserId, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session successfully revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllSessionsHandler logs the user out everywhere, including the current session.
+func (app *application) deleteAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.DeleteAllSessions(app.contextGetUser(r).UserId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out of every session"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"marketier/internal/data"
+	"marketier/internal/validator"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/tomasen/realip"
+)
+
+func (app *application) redirectTrackingLinkHandler(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	link, err := app.models.TrackingLinkModel.GetByCode(params.ByName("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	qs := r.URL.Query()
+
+	click := &data.Click{
+		LinkId:      link.LinkId,
+		IPHash:      app.hashIP(realip.FromRequest(r)),
+		UserAgent:   r.UserAgent(),
+		Referrer:    r.Referer(),
+		UTMSource:   app.readString(qs, "utm_source", ""),
+		UTMMedium:   app.readString(qs, "utm_medium", ""),
+		UTMCampaign: app.readString(qs, "utm_campaign", ""),
+		UTMTerm:     app.readString(qs, "utm_term", ""),
+		UTMContent:  app.readString(qs, "utm_content", ""),
+	}
+
+	// A failure to record the click shouldn't stop the shopper reaching the product,
+	// so log it and carry on with the redirect.
+	err = app.models.TrackingLinkModel.RecordClick(click)
+	if err != nil {
+		app.logError(r, err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf(app.config.tracking.productURL, link.ProductId), http.StatusFound)
+}
+
+func (app *application) listTrackingLinksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "link_id")
+	input.Filters.SortSafelist = []string{"link_id", "created_at", "clicks", "unique_visitors", "-link_id", "-created_at", "-clicks", "-unique_visitors"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Users who sell products see the links that point at their products and everyone else
+	// sees the links they own. Users who may manage tracking see everything.
+	var marketierId, ownerId int64
+
+	user := app.contextGetUser(r)
+	permissions := app.contextGetPermissions(r)
+
+	switch {
+	case permissions.Include("tracking:manage"):
+	case permissions.Include("products:write"):
+		ownerId = user.UserId
+	default:
+		marketierId = user.UserId
+	}
+
+	links, metadata, err := app.models.TrackingLinkModel.GetAll(marketierId, ownerId, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tracking_links": links, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showTrackingLinkHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	link, err := app.models.TrackingLinkModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	product, err := app.models.ProductModel.Get(link.ProductId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.ownsOrPermitted(r, link.MarketierId, "tracking:manage") && !app.ownsOrPermitted(r, product.OwnerId, "tracking:manage") {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"tracking_link": link}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
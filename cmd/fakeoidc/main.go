@@ -0,0 +1,56 @@
+// Command fakeoidc runs a local OpenID Connect provider that signs in anyone as any email
+// address, so that social login can be tried out and tested without the internet. Point
+// the API at it with:
+//
+//	-oidc-provider "fake http://localhost:4002 marketier-dev marketier-dev-secret"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"marketier/internal/auth/oidc"
+	"marketier/internal/jsonlog"
+)
+
+func main() {
+	var (
+		port         int
+		issuer       string
+		clientID     string
+		clientSecret string
+	)
+
+	flag.IntVar(&port, "port", 4002, "Fake provider port")
+	flag.StringVar(&issuer, "issuer", "http://localhost:4002", "Issuer URL the provider is reachable at")
+	flag.StringVar(&clientID, "client-id", "marketier-dev", "Client ID the API signs in with")
+	flag.StringVar(&clientSecret, "client-secret", "marketier-dev-secret", "Client secret the API signs in with")
+
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	provider, err := oidc.NewFakeServer(issuer, clientID, clientSecret)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", port),
+		Handler:      provider,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	logger.PrintInfo("starting fake oidc provider", map[string]string{
+		"addr":   srv.Addr,
+		"issuer": issuer,
+	})
+
+	err = srv.ListenAndServe()
+	logger.PrintFatal(err, nil)
+}
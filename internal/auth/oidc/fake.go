@@ -0,0 +1,281 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeServer is a minimal OpenID Connect provider for local development and testing. It
+// signs in whoever asks, as whatever email address they type, so it must never be
+// exposed to real users.
+type FakeServer struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	key   *rsa.PrivateKey
+	keyID string
+
+	mu    sync.Mutex
+	codes map[string]fakeGrant
+}
+
+type fakeGrant struct {
+	claims      Claims
+	redirectURI string
+	expiry      time.Time
+}
+
+func NewFakeServer(issuer, clientID, clientSecret string) (*FakeServer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := randomString(8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FakeServer{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		keyID:        keyID,
+		codes:        make(map[string]fakeGrant),
+	}, nil
+}
+
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		s.discovery(w, r)
+	case "/authorize":
+		s.authorize(w, r)
+	case "/token":
+		s.token(w, r)
+	case "/jwks":
+		s.jwks(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *FakeServer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+var fakeLoginForm = template.Must(template.New("login").Parse(`<!doctype html>
+<html>
+  <body>
+    <h1>Fake OpenID Connect provider</h1>
+    <form method="get" action="/authorize">
+      {{range $name, $values := .}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">{{end}}{{end}}
+      <input type="hidden" name="form" value="1">
+      <p><label>Email <input type="email" name="login_hint" required></label></p>
+      <p><label>Name <input type="text" name="name"></label></p>
+      <p><label><input type="checkbox" name="email_verified" value="true" checked> Email verified</label></p>
+      <p><button type="submit">Sign in</button></p>
+    </form>
+  </body>
+</html>
+`))
+
+// authorize signs in as the email address in login_hint, asking for one with a form if it
+// is missing, and redirects back with an authorization code.
+func (s *FakeServer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("client_id") != s.ClientID || q.Get("response_type") != "code" || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	email := q.Get("login_hint")
+	if email == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fakeLoginForm.Execute(w, q)
+		return
+	}
+
+	name := q.Get("name")
+	given, family := name, ""
+	if i := strings.LastIndex(name, " "); i > 0 {
+		given, family = name[:i], name[i+1:]
+	}
+
+	// The email address counts as verified unless the request says otherwise. An
+	// unticked checkbox isn't sent at all, so the form has to be told apart.
+	verified := q.Get("email_verified") != "false"
+	if q.Get("form") != "" {
+		verified = q.Get("email_verified") == "true"
+	}
+
+	// The same email address always signs in as the same subject.
+	subject := sha256.Sum256([]byte(strings.ToLower(email)))
+
+	grant := fakeGrant{
+		claims: Claims{
+			Issuer:        s.Issuer,
+			Subject:       hex.EncodeToString(subject[:8]),
+			Audience:      audience{s.ClientID},
+			Nonce:         q.Get("nonce"),
+			Email:         email,
+			EmailVerified: verified,
+			Name:          name,
+			GivenName:     given,
+			FamilyName:    family,
+		},
+		redirectURI: q.Get("redirect_uri"),
+		expiry:      time.Now().Add(time.Minute),
+	}
+
+	code, err := randomString(16)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	s.codes[code] = grant
+	s.mu.Unlock()
+
+	redirect, err := url.Parse(grant.redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token swaps a code from authorize for a signed ID token. Each code works once.
+func (s *FakeServer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	if clientID != s.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(s.ClientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	s.mu.Lock()
+	grant, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" || !ok || time.Now().After(grant.expiry) || grant.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	grant.claims.IssuedAt = now.Unix()
+	grant.claims.Expiry = now.Add(time.Hour).Unix()
+
+	idToken, err := s.sign(grant.claims)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken, err := randomString(16)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *FakeServer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jsonWebKeySet{Keys: []jsonWebKey{newJSONWebKey(s.keyID, &s.key.PublicKey)}})
+}
+
+func (s *FakeServer) sign(claims Claims) (string, error) {
+	header, err := encodeSegment(jwtHeader{Algorithm: "RS256", KeyID: s.keyID})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := encodeSegment(claims)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(header + "." + payload))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
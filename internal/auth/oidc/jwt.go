@@ -0,0 +1,210 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// verifyJWT checks an RS256 signed JWT against the key set and decodes its payload into
+// claims. Only RS256 is accepted, which every OpenID Connect provider has to support.
+func verifyJWT(ctx context.Context, raw string, keys *keySet, claims interface{}) error {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	if header.Algorithm != "RS256" {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	key, err := keys.get(ctx, header.KeyID)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	err = decodeSegment(parts[1], claims)
+	if err != nil {
+		return fmt.Errorf("%w: malformed payload", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, dst)
+}
+
+func encodeSegment(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// keySet caches a provider's signing keys. Providers rotate keys by publishing the new
+// one before using it, so an unknown key ID means it's time to fetch the set again, but
+// not more than once a minute.
+type keySet struct {
+	url        string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+func newKeySet(url string, httpClient *http.Client) *keySet {
+	return &keySet{
+		url:        url,
+		httpClient: httpClient,
+		keys:       make(map[string]*rsa.PublicKey),
+	}
+}
+
+func (s *keySet) get(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.lookup(keyID); ok {
+		return key, nil
+	}
+
+	if time.Since(s.fetchedAt) < time.Minute {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, keyID)
+	}
+
+	err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, ok := s.lookup(keyID); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, keyID)
+}
+
+// lookup finds the key by ID. Tokens without a key ID are accepted when the provider
+// publishes a single key.
+func (s *keySet) lookup(keyID string) (*rsa.PublicKey, bool) {
+	if keyID == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+
+	key, ok := s.keys[keyID]
+	return key, ok
+}
+
+func (s *keySet) fetch(ctx context.Context) error {
+	var set jsonWebKeySet
+
+	err := getJSON(ctx, s.httpClient, s.url, &set)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return err
+		}
+
+		keys[jwk.KeyID] = key
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("jwk %q: bad modulus", k.KeyID)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, fmt.Errorf("jwk %q: bad exponent", k.KeyID)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func newJSONWebKey(keyID string, key *rsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		KeyType:   "RSA",
+		KeyID:     keyID,
+		Use:       "sig",
+		Algorithm: "RS256",
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
@@ -0,0 +1,282 @@
+// Package oidc signs users in with external OpenID Connect providers using the
+// authorization code flow. Providers are found through OpenID Connect discovery, and the
+// ID tokens they issue are verified against the signing keys they publish.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid id token")
+	ErrExchange     = errors.New("authorization code exchange failed")
+	ErrProvider     = errors.New("request to the identity provider failed")
+)
+
+// Provider is an external identity provider users can sign in with.
+type Provider interface {
+	// Name identifies the provider in routes and in linked identities.
+	Name() string
+
+	// AuthURL returns where to send the user to sign in. The provider sends them back
+	// with the state unchanged and puts the nonce in the ID token it issues.
+	AuthURL(state, nonce string) string
+
+	// Exchange swaps the authorization code the user came back with for a raw ID token.
+	Exchange(ctx context.Context, code string) (string, error)
+
+	// Verify checks the ID token's signature against the provider's published keys, along
+	// with its issuer, audience, expiry and nonce, and returns its claims.
+	Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error)
+}
+
+// Claims holds the parts of an ID token that identify the user.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp,omitempty"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce,omitempty"`
+	Email         string   `json:"email,omitempty"`
+	EmailVerified bool     `json:"email_verified,omitempty"`
+	Name          string   `json:"name,omitempty"`
+	GivenName     string   `json:"given_name,omitempty"`
+	FamilyName    string   `json:"family_name,omitempty"`
+}
+
+// audience is a JSON string or array of strings, as the aud claim can be either.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+
+	*a = many
+	return nil
+}
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+
+	return json.Marshal([]string(a))
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Config describes a provider registered for the application.
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// ParseConfig reads a provider written as "name issuer client-id client-secret".
+func ParseConfig(s string) (Config, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return Config{}, fmt.Errorf("oidc provider must be in the form \"name issuer client-id client-secret\"")
+	}
+
+	return Config{
+		Name:         fields[0],
+		Issuer:       strings.TrimRight(fields[1], "/"),
+		ClientID:     fields[2],
+		ClientSecret: fields[3],
+	}, nil
+}
+
+// metadata is the part of a provider's discovery document this package needs.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client is a Provider backed by a standard OpenID Connect server.
+type Client struct {
+	config     Config
+	metadata   metadata
+	keys       *keySet
+	httpClient *http.Client
+}
+
+// Discover reads the provider's discovery document and returns a client for it.
+func Discover(ctx context.Context, config Config) (*Client, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	var md metadata
+
+	err := getJSON(ctx, httpClient, config.Issuer+"/.well-known/openid-configuration", &md)
+	if err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", config.Name, err)
+	}
+
+	if strings.TrimRight(md.Issuer, "/") != config.Issuer {
+		return nil, fmt.Errorf("discovering %s: issuer %q does not match %q", config.Name, md.Issuer, config.Issuer)
+	}
+
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, fmt.Errorf("discovering %s: discovery document is missing endpoints", config.Name)
+	}
+
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Client{
+		config:     config,
+		metadata:   md,
+		keys:       newKeySet(md.JWKSURI, httpClient),
+		httpClient: httpClient,
+	}, nil
+}
+
+func (c *Client) Name() string {
+	return c.config.Name
+}
+
+func (c *Client) AuthURL(state, nonce string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.config.ClientID)
+	params.Set("redirect_uri", c.config.RedirectURL)
+	params.Set("scope", strings.Join(c.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+
+	separator := "?"
+	if strings.Contains(c.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return c.metadata.AuthorizationEndpoint + separator + params.Encode()
+}
+
+func (c *Client) Exchange(ctx context.Context, code string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+
+	if res.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("%w: %s %s", ErrExchange, body.Error, body.ErrorDescription)
+	}
+
+	return body.IDToken, nil
+}
+
+// clockSkew is how far the provider's clock may be from ours.
+const clockSkew = time.Minute
+
+func (c *Client) Verify(ctx context.Context, rawIDToken, nonce string) (*Claims, error) {
+	var claims Claims
+
+	err := verifyJWT(ctx, rawIDToken, c.keys, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	switch {
+	case strings.TrimRight(claims.Issuer, "/") != c.config.Issuer:
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	case !claims.Audience.contains(c.config.ClientID):
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != c.config.ClientID:
+		return nil, fmt.Errorf("%w: unexpected authorized party", ErrInvalidToken)
+	case now.Add(-clockSkew).After(time.Unix(claims.Expiry, 0)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case now.Add(clockSkew).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return &claims, nil
+}
+
+func getJSON(ctx context.Context, httpClient *http.Client, url string, dst interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: GET %s: unexpected status %s", ErrProvider, url, res.Status)
+	}
+
+	err = json.NewDecoder(res.Body).Decode(dst)
+	if err != nil {
+		return fmt.Errorf("%w: GET %s: %v", ErrProvider, url, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,341 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "marketier"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:4000/v1/oidc/fake/callback"
+)
+
+// newTestProvider serves a FakeServer over HTTP and discovers a client for it.
+func newTestProvider(t *testing.T) (*FakeServer, *Client) {
+	t.Helper()
+
+	var fake *FakeServer
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	fake, err := NewFakeServer(srv.URL, testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := Discover(context.Background(), Config{
+		Name:         "fake",
+		Issuer:       srv.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return fake, client
+}
+
+// authorize follows the client's AuthURL as a user signing in with the email address, and
+// returns the code and state the provider redirects back with.
+func authorize(t *testing.T, client *Client, state, nonce, email string) (string, string) {
+	t.Helper()
+
+	httpClient := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := httpClient.Get(client.AuthURL(state, nonce) + "&" + url.Values{"login_hint": {email}, "name": {"Ada Lovelace"}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s, want a redirect", res.Status)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(location.String(), testRedirectURL) {
+		t.Fatalf("redirected to %s, want %s", location, testRedirectURL)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestFlow(t *testing.T) {
+	_, client := newTestProvider(t)
+	ctx := context.Background()
+
+	code, state := authorize(t, client, "the-state", "the-nonce", "ada@example.com")
+	if state != "the-state" {
+		t.Errorf("state = %q, want it sent back unchanged", state)
+	}
+
+	rawIDToken, err := client.Exchange(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := client.Verify(ctx, rawIDToken, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Email != "ada@example.com" || !claims.EmailVerified {
+		t.Errorf("email = %q verified %t, want ada@example.com verified", claims.Email, claims.EmailVerified)
+	}
+
+	if claims.GivenName != "Ada" || claims.FamilyName != "Lovelace" {
+		t.Errorf("names = %q %q, want Ada Lovelace", claims.GivenName, claims.FamilyName)
+	}
+
+	if claims.Subject == "" {
+		t.Error("subject is empty")
+	}
+
+	// The same email address signs in as the same subject every time.
+	code, _ = authorize(t, client, "another-state", "another-nonce", "ADA@example.com")
+
+	rawIDToken, err = client.Exchange(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	again, err := client.Verify(ctx, rawIDToken, "another-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again.Subject != claims.Subject {
+		t.Errorf("second sign-in subject = %q, want %q", again.Subject, claims.Subject)
+	}
+}
+
+func TestExchangeRejectsReusedCode(t *testing.T) {
+	_, client := newTestProvider(t)
+	ctx := context.Background()
+
+	code, _ := authorize(t, client, "state", "nonce", "ada@example.com")
+
+	_, err := client.Exchange(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Exchange(ctx, code)
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("second exchange error = %v, want ErrExchange", err)
+	}
+
+	_, err = client.Exchange(ctx, "made-up")
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("unknown code error = %v, want ErrExchange", err)
+	}
+}
+
+func TestVerifyRejectsBadClaims(t *testing.T) {
+	fake, client := newTestProvider(t)
+	now := time.Now()
+
+	valid := func() Claims {
+		return Claims{
+			Issuer:        fake.Issuer,
+			Subject:       "subject",
+			Audience:      audience{testClientID},
+			IssuedAt:      now.Unix(),
+			Expiry:        now.Add(time.Hour).Unix(),
+			Nonce:         "nonce",
+			Email:         "ada@example.com",
+			EmailVerified: true,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Claims)
+	}{
+		{"wrong issuer", func(c *Claims) { c.Issuer = "https://attacker.example.com" }},
+		{"wrong audience", func(c *Claims) { c.Audience = audience{"someone-else"} }},
+		{"missing audience", func(c *Claims) { c.Audience = nil }},
+		{"several audiences without azp", func(c *Claims) { c.Audience = audience{testClientID, "someone-else"} }},
+		{"several audiences for another party", func(c *Claims) {
+			c.Audience = audience{testClientID, "someone-else"}
+			c.AuthorizedBy = "someone-else"
+		}},
+		{"expired", func(c *Claims) { c.Expiry = now.Add(-2 * clockSkew).Unix() }},
+		{"issued in the future", func(c *Claims) { c.IssuedAt = now.Add(2 * clockSkew).Unix() }},
+		{"wrong nonce", func(c *Claims) { c.Nonce = "replayed" }},
+		{"missing nonce", func(c *Claims) { c.Nonce = "" }},
+		{"missing subject", func(c *Claims) { c.Subject = "" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := valid()
+			tt.modify(&claims)
+
+			rawIDToken, err := fake.sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.Verify(context.Background(), rawIDToken, "nonce")
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+
+	t.Run("within clock skew", func(t *testing.T) {
+		claims := valid()
+		claims.Expiry = now.Add(-clockSkew / 2).Unix()
+		claims.Audience = audience{testClientID, "someone-else"}
+		claims.AuthorizedBy = testClientID
+
+		rawIDToken, err := fake.sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Verify(context.Background(), rawIDToken, "nonce")
+		if err != nil {
+			t.Errorf("Verify error = %v, want nil", err)
+		}
+	})
+}
+
+func TestVerifyRejectsBadSignatures(t *testing.T) {
+	fake, client := newTestProvider(t)
+
+	other, err := NewFakeServer(fake.Issuer, testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.keyID = fake.keyID
+
+	claims := Claims{
+		Issuer:   fake.Issuer,
+		Subject:  "subject",
+		Audience: audience{testClientID},
+		IssuedAt: time.Now().Unix(),
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+		Nonce:    "nonce",
+	}
+
+	signed, err := fake.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forged, err := other.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(signed, ".")
+
+	claims.Subject = "someone-else"
+	tamperedPayload, err := encodeSegment(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noneHeader, err := encodeSegment(jwtHeader{Algorithm: "none", KeyID: fake.keyID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"signed with another key", forged},
+		{"payload changed", parts[0] + "." + tamperedPayload + "." + parts[2]},
+		{"alg none", noneHeader + "." + parts[1] + "."},
+		{"malformed", "not-a-jwt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Verify(context.Background(), tt.token, "nonce")
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Verify error = %v, want ErrInvalidToken", err)
+			}
+		})
+	}
+}
+
+func TestDiscoverRejectsMismatchedIssuer(t *testing.T) {
+	fake, err := NewFakeServer("https://idp.example.com", testClientID, testClientSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	_, err = Discover(context.Background(), Config{Name: "fake", Issuer: srv.URL, ClientID: testClientID})
+	if err == nil {
+		t.Error("Discover accepted a provider claiming another issuer")
+	}
+}
+
+func TestProviderUnreachable(t *testing.T) {
+	fake, client := newTestProvider(t)
+	ctx := context.Background()
+
+	claims := Claims{
+		Issuer:   fake.Issuer,
+		Subject:  "subject",
+		Audience: audience{testClientID},
+		IssuedAt: time.Now().Unix(),
+		Expiry:   time.Now().Add(time.Hour).Unix(),
+		Nonce:    "nonce",
+	}
+
+	rawIDToken, err := fake.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Point the client at an address nothing is listening on, as if the provider had gone
+	// down after discovery.
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	client.metadata.TokenEndpoint = srv.URL + "/token"
+	client.keys = newKeySet(srv.URL+"/jwks", client.httpClient)
+
+	_, err = client.Exchange(ctx, "code")
+	if !errors.Is(err, ErrProvider) {
+		t.Errorf("Exchange error = %v, want ErrProvider", err)
+	}
+
+	_, err = client.Verify(ctx, rawIDToken, "nonce")
+	if !errors.Is(err, ErrProvider) {
+		t.Errorf("Verify error = %v, want ErrProvider", err)
+	}
+
+	_, err = Discover(ctx, Config{Name: "fake", Issuer: srv.URL, ClientID: testClientID})
+	if !errors.Is(err, ErrProvider) {
+		t.Errorf("Discover error = %v, want ErrProvider", err)
+	}
+}
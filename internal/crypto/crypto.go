@@ -0,0 +1,256 @@
+// Package crypto encrypts values for storage with envelope encryption. Each value is
+// sealed with AES-256-GCM under a data key of its own, and the data key is sealed in turn
+// under a named key-encryption key. The name travels with the value, so that old keys can
+// be kept for reading while new values use the current one, and rotating to a new key only
+// means sealing each data key again.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// KeySize is the length of a key-encryption key: 32 bytes, for AES-256.
+	KeySize = 32
+
+	// prefix marks an encrypted value, which is written as
+	// prefix + key id + ":" + sealed data key + ":" + sealed value.
+	prefix = "enc:v1:"
+)
+
+var (
+	ErrUnknownKey       = errors.New("crypto: value was encrypted with an unknown key")
+	ErrMalformed        = errors.New("crypto: malformed encrypted value")
+	ErrInvalidKeyConfig = errors.New("crypto: keys must be given as id:base64-key")
+	ErrNotEncrypted     = errors.New("crypto: value is not encrypted")
+)
+
+var (
+	keyIdRX  = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	encoding = base64.RawURLEncoding
+)
+
+// Keyring holds the key-encryption keys by id, and which of them new values are encrypted
+// with.
+type Keyring struct {
+	currentId        string
+	keys             map[string]cipher.AEAD
+	requireEncrypted bool
+}
+
+// ParseKey reads a key written as "id:base64-key", where the key is KeySize bytes in
+// standard base64.
+func ParseKey(s string) (string, []byte, error) {
+	id, encoded, found := strings.Cut(s, ":")
+	if !found || !keyIdRX.MatchString(id) {
+		return "", nil, ErrInvalidKeyConfig
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, ErrInvalidKeyConfig
+	}
+
+	if len(key) != KeySize {
+		return "", nil, fmt.Errorf("crypto: key %q must be %d bytes long", id, KeySize)
+	}
+
+	return id, key, nil
+}
+
+// NewKeyring returns a keyring holding the keys, which encrypts with the key named
+// currentId.
+func NewKeyring(currentId string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[currentId]; !ok {
+		return nil, fmt.Errorf("crypto: no key with the current id %q", currentId)
+	}
+
+	k := &Keyring{currentId: currentId, keys: make(map[string]cipher.AEAD)}
+
+	for id, key := range keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// CurrentId returns the id of the key that new values are encrypted with.
+func (k *Keyring) CurrentId() string {
+	return k.currentId
+}
+
+// RequireEncrypted stops the keyring's users from accepting plaintext stored before
+// encryption was turned on. Once every value has been encrypted, a plaintext value can
+// only have been written by someone going around the application.
+func (k *Keyring) RequireEncrypted() {
+	k.requireEncrypted = true
+}
+
+// CheckPlaintext returns ErrNotEncrypted for a value that isn't encrypted, if the keyring
+// requires values to be.
+func (k *Keyring) CheckPlaintext(value string) error {
+	if k.requireEncrypted && !IsEncrypted(value) {
+		return ErrNotEncrypted
+	}
+
+	return nil
+}
+
+// IsEncrypted reports whether the value was written by Encrypt, as opposed to being
+// plaintext stored before encryption was turned on.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Encrypt seals the plaintext under a new data key, and the data key under the current
+// key. The associated data isn't stored, but the same has to be passed to Decrypt; it ties
+// the value to where it's kept, so that it can't be copied somewhere else.
+func (k *Keyring) Encrypt(plaintext, associatedData []byte) (string, error) {
+	dataKey := make([]byte, KeySize)
+
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealedValue, err := seal(dataAEAD, plaintext, associatedData)
+	if err != nil {
+		return "", err
+	}
+
+	sealedKey, err := seal(k.keys[k.currentId], dataKey, []byte(k.currentId))
+	if err != nil {
+		return "", err
+	}
+
+	return join(k.currentId, sealedKey, sealedValue), nil
+}
+
+// Decrypt opens a value written by Encrypt.
+func (k *Keyring) Decrypt(value string, associatedData []byte) ([]byte, error) {
+	id, sealedKey, sealedValue, err := split(value)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := k.openDataKey(id, sealedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(dataAEAD, sealedValue, associatedData)
+}
+
+// Rewrap seals the value's data key under the current key, leaving the value itself
+// untouched. A value already under the current key is returned as it is.
+func (k *Keyring) Rewrap(value string) (string, error) {
+	id, sealedKey, sealedValue, err := split(value)
+	if err != nil {
+		return "", err
+	}
+
+	if id == k.currentId {
+		return value, nil
+	}
+
+	dataKey, err := k.openDataKey(id, sealedKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealedKey, err = seal(k.keys[k.currentId], dataKey, []byte(k.currentId))
+	if err != nil {
+		return "", err
+	}
+
+	return join(k.currentId, sealedKey, sealedValue), nil
+}
+
+func (k *Keyring) openDataKey(id string, sealedKey []byte) ([]byte, error) {
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	return open(aead, sealedKey, []byte(id))
+}
+
+// seal encrypts the plaintext with a random nonce, which is put in front of the
+// ciphertext.
+func seal(aead cipher.AEAD, plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+func open(aead cipher.AEAD, sealed, associatedData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, associatedData)
+}
+
+func join(id string, sealedKey, sealedValue []byte) string {
+	return prefix + id + ":" + encoding.EncodeToString(sealedKey) + ":" + encoding.EncodeToString(sealedValue)
+}
+
+func split(value string) (string, []byte, []byte, error) {
+	if !IsEncrypted(value) {
+		return "", nil, nil, ErrMalformed
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+
+	sealedKey, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+
+	sealedValue, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+
+	return parts[0], sealedKey, sealedValue, nil
+}
@@ -0,0 +1,283 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testKey returns a key of KeySize bytes, all set to b.
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func newTestKeyring(t *testing.T, currentId string, keys map[string][]byte) *Keyring {
+	t.Helper()
+
+	keyring, err := NewKeyring(currentId, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyring
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	keyring := newTestKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	for _, plaintext := range []string{"", "1 Example Street", "ünïcødé"} {
+		value, err := keyring.Encrypt([]byte(plaintext), []byte("address:1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !IsEncrypted(value) {
+			t.Errorf("Encrypt(%q) = %q, which IsEncrypted doesn't recognise", plaintext, value)
+		}
+
+		if plaintext != "" && strings.Contains(value, plaintext) {
+			t.Errorf("Encrypt(%q) = %q, which contains the plaintext", plaintext, value)
+		}
+
+		got, err := keyring.Decrypt(value, []byte("address:1"))
+		if err != nil {
+			t.Fatalf("Decrypt(Encrypt(%q)): %v", plaintext, err)
+		}
+
+		if string(got) != plaintext {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q", plaintext, got)
+		}
+	}
+
+	first, err := keyring.Encrypt([]byte("same"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := keyring.Encrypt([]byte("same"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == second {
+		t.Error("Encrypt gave the same value twice for the same plaintext")
+	}
+}
+
+func TestDecryptWrongAssociatedData(t *testing.T) {
+	keyring := newTestKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	value, err := keyring.Encrypt([]byte("1 Example Street"), []byte("address:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, associatedData := range []string{"address:2", "gender:1", "address", ""} {
+		_, err := keyring.Decrypt(value, []byte(associatedData))
+		if err == nil {
+			t.Errorf("Decrypt with associated data %q succeeded, want an error", associatedData)
+		}
+	}
+}
+
+func TestDecryptUnknownKey(t *testing.T) {
+	old := newTestKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	value, err := old.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := newTestKeyring(t, "k2", map[string][]byte{"k2": testKey(2)})
+
+	_, err = keyring.Decrypt(value, nil)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Decrypt error = %v, want ErrUnknownKey", err)
+	}
+
+	_, err = keyring.Rewrap(value)
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Rewrap error = %v, want ErrUnknownKey", err)
+	}
+
+	// A key with the right id but different bytes doesn't open the value either.
+	impostor := newTestKeyring(t, "k1", map[string][]byte{"k1": testKey(9)})
+
+	_, err = impostor.Decrypt(value, nil)
+	if err == nil {
+		t.Error("Decrypt with a different key under the same id succeeded, want an error")
+	}
+}
+
+func TestRewrapAfterRotation(t *testing.T) {
+	old := newTestKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	value, err := old.Encrypt([]byte("1 Example Street"), []byte("address:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := newTestKeyring(t, "k2", map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+
+	// Before the value is rewrapped, the old key still reads it.
+	got, err := rotated.Decrypt(value, []byte("address:1"))
+	if err != nil || string(got) != "1 Example Street" {
+		t.Fatalf("Decrypt before rewrapping = %q, %v", got, err)
+	}
+
+	rewrapped, err := rotated.Rewrap(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(rewrapped, prefix+"k2:") {
+		t.Errorf("Rewrap = %q, want it under k2", rewrapped)
+	}
+
+	// The value itself is left as it was; only the data key is sealed again.
+	if rewrapped[strings.LastIndex(rewrapped, ":"):] != value[strings.LastIndex(value, ":"):] {
+		t.Error("Rewrap changed the sealed value")
+	}
+
+	again, err := rotated.Rewrap(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again != rewrapped {
+		t.Error("Rewrap changed a value already under the current key")
+	}
+
+	// Once rewrapped, the old key can be retired.
+	retired := newTestKeyring(t, "k2", map[string][]byte{"k2": testKey(2)})
+
+	got, err = retired.Decrypt(rewrapped, []byte("address:1"))
+	if err != nil || string(got) != "1 Example Street" {
+		t.Errorf("Decrypt after rewrapping = %q, %v", got, err)
+	}
+
+	_, err = retired.Decrypt(rewrapped, []byte("address:2"))
+	if err == nil {
+		t.Error("Decrypt after rewrapping accepted other associated data")
+	}
+}
+
+func TestDecryptMalformed(t *testing.T) {
+	keyring := newTestKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	value, err := keyring.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"plaintext", "1 Example Street"},
+		{"empty", ""},
+		{"other version", "enc:v2:" + strings.Join(parts, ":")},
+		{"missing the sealed value", prefix + parts[0] + ":" + parts[1]},
+		{"extra part", value + ":" + parts[2]},
+		{"data key not base64", prefix + parts[0] + ":!!!:" + parts[2]},
+		{"value not base64", prefix + parts[0] + ":" + parts[1] + ":!!!"},
+		{"value shorter than a nonce", prefix + parts[0] + ":" + parts[1] + ":" + encoding.EncodeToString([]byte("short"))},
+		{"data key shorter than a nonce", prefix + parts[0] + "::" + parts[2]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keyring.Decrypt(tt.value, nil)
+			if !errors.Is(err, ErrMalformed) {
+				t.Errorf("Decrypt error = %v, want ErrMalformed", err)
+			}
+		})
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		sealed, err := encoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed[len(sealed)-1] ^= 1
+
+		_, err = keyring.Decrypt(prefix+parts[0]+":"+parts[1]+":"+encoding.EncodeToString(sealed), nil)
+		if err == nil {
+			t.Error("Decrypt accepted a tampered value")
+		}
+	})
+}
+
+func TestParseKey(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testKey(1))
+
+	id, key, err := ParseKey("primary-2024_1:" + encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "primary-2024_1" || !bytes.Equal(key, testKey(1)) {
+		t.Errorf("ParseKey = %q, %x", id, key)
+	}
+
+	tests := []struct {
+		name string
+		s    string
+	}{
+		{"no id", encoded},
+		{"empty id", ":" + encoded},
+		{"id with a colon", "a:b:" + encoded},
+		{"not base64", "k1:not base64"},
+		{"too short", "k1:" + base64.StdEncoding.EncodeToString(testKey(1)[:16])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := ParseKey(tt.s)
+			if err == nil {
+				t.Errorf("ParseKey(%q) succeeded, want an error", tt.s)
+			}
+		})
+	}
+}
+
+func TestNewKeyring(t *testing.T) {
+	_, err := NewKeyring("k2", map[string][]byte{"k1": testKey(1)})
+	if err == nil {
+		t.Error("NewKeyring accepted a current id with no key")
+	}
+
+	_, err = NewKeyring("k1", map[string][]byte{"k1": testKey(1)[:7]})
+	if err == nil {
+		t.Error("NewKeyring accepted a key of the wrong length")
+	}
+}
+
+func TestRequireEncrypted(t *testing.T) {
+	keyring := newTestKeyring(t, "k1", map[string][]byte{"k1": testKey(1)})
+
+	value, err := keyring.Encrypt([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stored := range []string{"1 Example Street", value} {
+		if err := keyring.CheckPlaintext(stored); err != nil {
+			t.Errorf("CheckPlaintext(%q) = %v before encryption is required, want nil", stored, err)
+		}
+	}
+
+	keyring.RequireEncrypted()
+
+	if err := keyring.CheckPlaintext("1 Example Street"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("CheckPlaintext of plaintext = %v, want ErrNotEncrypted", err)
+	}
+
+	if err := keyring.CheckPlaintext(value); err != nil {
+		t.Errorf("CheckPlaintext of an encrypted value = %v, want nil", err)
+	}
+}
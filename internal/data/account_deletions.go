@@ -0,0 +1,288 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// AccountDeletion is a request to erase an account once its grace period is over.
+type AccountDeletion struct {
+	UserId      int64     `json:"user_id"`
+	RequestedBy int64     `json:"requested_by"`
+	RequestedAt time.Time `json:"requested_at"`
+	DeleteAfter time.Time `json:"delete_after"`
+}
+
+// Erasure lists what an erased account left on disk: the images of its proposals and
+// products, and its data export, which the caller removes once the rows are gone.
+type Erasure struct {
+	UserId         int64
+	ProposalIds    []int64
+	ProductIds     []int64
+	ExportFileName string
+}
+
+type AccountDeletionModel struct {
+	DB *sql.DB
+}
+
+// Request marks the account as pending deletion, which signs the user out everywhere,
+// and schedules it to be erased.
+func (m AccountDeletionModel) Request(user *BaseUserAccount, deletion *AccountDeletion, event *SecurityEvent) error {
+	query := `
+        INSERT INTO account_deletions (user_id, requested_by, delete_after)
+        VALUES ($1, $2, $3)
+        RETURNING requested_at`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = setAccountStatus(ctx, tx, user, AccountStatusPendingDeletion)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, user.UserId, deletion.RequestedBy, deletion.DeleteAfter).Scan(&deletion.RequestedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	user.AccountStatus = AccountStatusPendingDeletion
+
+	return nil
+}
+
+// Cancel calls off a deletion the user asked for themself and reactivates the account.
+// Deletions asked for by an administrator can't be cancelled this way.
+func (m AccountDeletionModel) Cancel(user *BaseUserAccount, event *SecurityEvent) error {
+	query := `
+        DELETE FROM account_deletions
+        WHERE user_id = $1 AND requested_by = $1`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	result, err := tx.ExecContext(ctx, query, user.UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return ErrRecordNotFound
+	}
+
+	err = setAccountStatus(ctx, tx, user, AccountStatusActivated)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	user.AccountStatus = AccountStatusActivated
+
+	return nil
+}
+
+// GetDue returns the ids of the accounts whose grace period is over.
+func (m AccountDeletionModel) GetDue() ([]int64, error) {
+	query := `
+        SELECT user_id
+        FROM account_deletions
+        WHERE delete_after <= NOW()
+        ORDER BY delete_after`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Erase removes an account whose grace period is over. Reviews and messages are kept for
+// the other people who took part, but no longer say who wrote them. Conversions and the
+// ledger outlive the account, with the references to it cleared by their foreign keys.
+// Everything else, including the personal details on the account itself, is deleted. The
+// security log keeps its events about the account, as a record that it was erased, but no
+// longer says where the user connected from.
+func (m AccountDeletionModel) Erase(userID int64) (*Erasure, error) {
+	lockQuery := `
+        SELECT base_users.email
+        FROM account_deletions
+        INNER JOIN base_users ON base_users.user_id = account_deletions.user_id
+        WHERE account_deletions.user_id = $1
+        AND account_deletions.delete_after <= NOW()
+        AND base_users.account_status = $2
+        FOR UPDATE`
+
+	// Proposals on the user's products are deleted along with the products, so their
+	// images have to go too.
+	proposalsQuery := `
+        SELECT proposal_id
+        FROM proposals
+        WHERE marketier_id = $1
+        OR product_id IN (SELECT product_id FROM products WHERE owner_id = $1)`
+
+	productsQuery := `
+        SELECT product_id
+        FROM products
+        WHERE owner_id = $1`
+
+	exportQuery := `
+        SELECT file_name
+        FROM data_exports
+        WHERE user_id = $1`
+
+	queries := []string{
+		`UPDATE reviews SET user_id = NULL WHERE user_id = $1`,
+		`UPDATE messages SET sender_id = NULL WHERE sender_id = $1`,
+		`DELETE FROM marketiers WHERE user_id = $1`,
+		`DELETE FROM product_owners WHERE user_id = $1`,
+		`DELETE FROM base_users WHERE user_id = $1`,
+	}
+
+	attemptsQuery := `
+        DELETE FROM login_attempts
+        WHERE kind = $1 AND key = $2`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var email string
+
+	err = tx.QueryRowContext(ctx, lockQuery, userID, AccountStatusPendingDeletion).Scan(&email)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	erasure := &Erasure{UserId: userID}
+
+	erasure.ProposalIds, err = queryIds(ctx, tx, proposalsQuery, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	erasure.ProductIds, err = queryIds(ctx, tx, productsQuery, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, exportQuery, userID).Scan(&erasure.ExportFileName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, attemptsQuery, loginAttemptEmail, email)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = forgetSecurityEventOrigins(ctx, tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = insertSecurityEvent(ctx, tx, &SecurityEvent{UserId: userID, Type: SecurityEventAccountErased})
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return erasure, nil
+}
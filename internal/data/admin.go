@@ -0,0 +1,419 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"marketier/internal/crypto"
+	"marketier/internal/validator"
+	"time"
+)
+
+// Administrative actions recorded in the audit trail.
+const (
+	AdminActionSuspend            = "suspend"
+	AdminActionReinstate          = "reinstate"
+	AdminActionForcePasswordReset = "force_password_reset"
+	AdminActionRevokeTokens       = "revoke_tokens"
+	AdminActionChangeAccountType  = "change_account_type"
+	AdminActionGrantPermissions   = "grant_permissions"
+	AdminActionRevokePermissions  = "revoke_permissions"
+)
+
+// AuditEntry records an administrator acting on a user's account. Details holds a short
+// description of what changed, such as the old and new account type.
+type AuditEntry struct {
+	EntryId      int64     `json:"entry_id"`
+	AdminId      int64     `json:"admin_id"`
+	TargetUserId int64     `json:"target_user_id"`
+	Action       string    `json:"action"`
+	Reason       string    `json:"reason"`
+	Details      string    `json:"details,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func ValidateAuditReason(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// insertAuditEntry writes the entry using the caller's transaction, so that an action and
+// its audit record are committed together or not at all.
+func insertAuditEntry(ctx context.Context, tx *sql.Tx, entry *AuditEntry) error {
+	query := `
+        INSERT INTO admin_audit (admin_id, target_user_id, action, reason, details)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING entry_id, created_at`
+
+	args := []interface{}{entry.AdminId, entry.TargetUserId, entry.Action, entry.Reason, entry.Details}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&entry.EntryId, &entry.CreatedAt)
+}
+
+type AdminModel struct {
+	DB      *sql.DB
+	Keyring *crypto.Keyring
+}
+
+// SearchUsers lists users of every account type. An empty search or status, or an
+// accountType of 0, disables that filter.
+func (m AdminModel) SearchUsers(search, status string, accountType int, filters Filters) ([]*BaseUserAccount, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), user_id, first_name, last_name, email, date_of_birth, gender, address, account_creation_time, last_login_time, account_status, version, account_type
+        FROM base_users
+        WHERE (first_name ILIKE '%%' || $1 || '%%' OR last_name ILIKE '%%' || $1 || '%%' OR email ILIKE '%%' || $1 || '%%' OR $1 = '')
+        AND (account_status = $2 OR $2 = '')
+        AND (account_type = $3 OR $3 = 0)
+        ORDER BY %s %s, user_id ASC
+        LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{search, status, accountType, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []*BaseUserAccount{}
+
+	for rows.Next() {
+		var user BaseUserAccount
+
+		err := rows.Scan(
+			&totalRecords,
+			&user.UserId,
+			&user.FirstName,
+			&user.LastName,
+			&user.Email,
+			encryptedTime(m.Keyring, columnDateOfBirth, &user.UserId, &user.DateOfBirth),
+			encryptedString(m.Keyring, columnGender, &user.UserId, &user.Gender),
+			encryptedString(m.Keyring, columnAddress, &user.UserId, &user.Address),
+			&user.AccountCreationTime,
+			&user.LastLoginTime,
+			&user.AccountStatus,
+			&user.Version,
+			&user.AccountType,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return users, metadata, nil
+}
+
+// SetAccountStatus moves the user to the given status and records the action. Suspending
+// a user also signs them out everywhere.
+func (m AdminModel) SetAccountStatus(user *BaseUserAccount, status string, entry *AuditEntry) error {
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = setAccountStatus(ctx, tx, user, status)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	user.AccountStatus = status
+
+	return nil
+}
+
+// ForcePasswordReset replaces the user's password with a random one that is never shared
+// and signs them out everywhere, so that the only way back in is a password reset. It
+// returns the password reset token to send to the user, which is only stored if the rest
+// of the reset is.
+func (m AdminModel) ForcePasswordReset(user *BaseUserAccount, resetTTL time.Duration, entry *AuditEntry, event *SecurityEvent) (*Token, error) {
+	err := user.Password.setRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        UPDATE base_users
+        SET password = $1, version = version + 1
+        WHERE user_id = $2 AND version = $3
+        RETURNING version`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.UserId, user.Version).Scan(&user.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	err = deleteTokensForUser(ctx, tx, user.UserId, ScopeAuthentication, ScopeRefresh, ScopePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	token, err := insertToken(ctx, tx, user.UserId, resetTTL, ScopePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// RevokeTokens deletes every token the user holds, whatever its scope.
+func (m AdminModel) RevokeTokens(userId int64, entry *AuditEntry) error {
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = deleteTokensForUser(ctx, tx, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangeAccountType moves the user to another account type, creating an empty marketier
+// or product owner profile for them if they don't have one yet. Existing profiles are
+// kept so that sales history survives a round trip between types.
+func (m AdminModel) ChangeAccountType(user *BaseUserAccount, accountType int8, entry *AuditEntry, event *SecurityEvent) error {
+	query := `
+        UPDATE base_users
+        SET account_type = $1, version = version + 1
+        WHERE user_id = $2 AND version = $3
+        RETURNING version`
+
+	marketierQuery := `
+        INSERT INTO marketiers (user_id, display_name, about, sales_generated, tier)
+        VALUES ($1, $2, '', 0, 0)
+        ON CONFLICT (user_id) DO NOTHING`
+
+	productOwnerQuery := `
+        INSERT INTO product_owners (user_id, display_name, about, sales_generated)
+        VALUES ($1, $2, '', 0)
+        ON CONFLICT (user_id) DO NOTHING`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, accountType, user.UserId, user.Version).Scan(&user.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	displayName := user.FirstName + " " + user.LastName
+
+	switch accountType {
+	case 2:
+		_, err = tx.ExecContext(ctx, marketierQuery, user.UserId, displayName)
+	case 3:
+		_, err = tx.ExecContext(ctx, productOwnerQuery, user.UserId, displayName)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	user.AccountType = accountType
+
+	return nil
+}
+
+// GrantPermissions grants the codes to the user on top of their role's permissions and
+// records the action.
+func (m AdminModel) GrantPermissions(userId int64, codes []string, entry *AuditEntry, event *SecurityEvent) error {
+	return m.changePermissions(userId, codes, addPermissionsForUser, entry, event)
+}
+
+// RevokePermissions takes back codes granted to the user directly and records the action.
+func (m AdminModel) RevokePermissions(userId int64, codes []string, entry *AuditEntry, event *SecurityEvent) error {
+	return m.changePermissions(userId, codes, removePermissionsForUser, entry, event)
+}
+
+func (m AdminModel) changePermissions(userId int64, codes []string, change func(context.Context, *sql.Tx, int64, ...string) error, entry *AuditEntry, event *SecurityEvent) error {
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = change(ctx, tx, userId, codes...)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertAuditEntry(ctx, tx, entry)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAuditTrail lists audit entries, newest first by default. An adminId or targetUserId
+// of 0, or an empty action, disables that filter.
+func (m AdminModel) GetAuditTrail(adminId, targetUserId int64, action string, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), entry_id, COALESCE(admin_id, 0), target_user_id, action, reason, details, created_at
+        FROM admin_audit
+        WHERE (admin_id = $1 OR $1 = 0)
+        AND (target_user_id = $2 OR $2 = 0)
+        AND (action = $3 OR $3 = '')
+        ORDER BY %s %s, entry_id DESC
+        LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{adminId, targetUserId, action, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.EntryId,
+			&entry.AdminId,
+			&entry.TargetUserId,
+			&entry.Action,
+			&entry.Reason,
+			&entry.Details,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
@@ -0,0 +1,260 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"marketier/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// API keys are written "mk_<prefix>_<secret>". The prefix is stored as is so that the key
+// can be found, and shown in listings so users can tell their keys apart.
+const apiKeyMarker = "mk"
+
+// An API key can't carry the permissions to create API keys or change two-factor
+// authentication, so that a leaked key can't be used to mint more or to weaken the
+// owner's login, nor the permissions to administer users or grant permissions, so that it
+// can't be used to take over other accounts.
+var apiKeyForbiddenScopes = []string{"api_keys:write", "mfa:write", "users:manage", "permissions:write"}
+
+type APIKey struct {
+	Id         int64      `json:"id"`
+	UserId     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Expiry     *time.Time `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Permissions returns the permissions a request made with the key has: the key's scopes,
+// less any the owner no longer holds themselves and any that keys may no longer carry.
+func (k *APIKey) Permissions(ownerPermissions Permissions) Permissions {
+	permissions := Permissions{}
+
+	for _, scope := range k.Scopes {
+		if ownerPermissions.Include(scope) && !validator.In(scope, apiKeyForbiddenScopes...) {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	return permissions
+}
+
+// ValidateAPIKey checks the key's details, and that its scopes are all permissions the
+// owner holds.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, ownerPermissions Permissions) {
+	v.Check(key.Name != "", "name", "must be provided")
+	v.Check(len(key.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(len(key.Scopes) > 0, "scopes", "must contain at least 1 scope")
+	v.Check(validator.Unique(key.Scopes), "scopes", "must not contain duplicate values")
+
+	for _, scope := range key.Scopes {
+		v.Check(ownerPermissions.Include(scope), "scopes", "must only contain permissions you hold")
+		v.Check(!validator.In(scope, apiKeyForbiddenScopes...), "scopes", "must not contain "+scope)
+	}
+
+	if key.Expiry != nil {
+		v.Check(key.Expiry.After(time.Now()), "expiry", "must be in the future")
+	}
+}
+
+// splitAPIKey breaks a key into its prefix and secret.
+func splitAPIKey(plaintext string) (string, string, bool) {
+	parts := strings.Split(plaintext, "_")
+	if len(parts) != 3 || parts[0] != apiKeyMarker || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
+
+func ValidateAPIKeyPlaintext(v *validator.Validator, plaintext string) {
+	_, secret, ok := splitAPIKey(plaintext)
+	v.Check(ok, "api_key", "must be in the form mk_<prefix>_<secret>")
+	v.Check(len(secret) == 26, "api_key", "must have a 26 byte secret")
+}
+
+func hashAPIKeySecret(secret string) []byte {
+	hash := sha256.Sum256([]byte(secret))
+	return hash[:]
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+// Insert generates the key and stores it, returning the full key. Only its hash is kept,
+// so this is the only time it is available.
+func (m APIKeyModel) Insert(key *APIKey) (string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	randomBytes := make([]byte, 21)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	// 5 random bytes make an 8 character prefix and the other 16 a 26 character secret.
+	key.Prefix = strings.ToLower(encoding.EncodeToString(randomBytes[:5]))
+	secret := encoding.EncodeToString(randomBytes[5:])
+	key.Hash = hashAPIKeySecret(secret)
+
+	query := `
+        INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expiry)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at`
+
+	args := []interface{}{key.UserId, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.Expiry}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&key.Id, &key.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+
+	return apiKeyMarker + "_" + key.Prefix + "_" + secret, nil
+}
+
+// GetForKey finds the key and checks its secret. Unknown, wrong and expired keys are all
+// not found. The key's last used time is moved forward, at most once a minute.
+func (m APIKeyModel) GetForKey(plaintext string) (*APIKey, error) {
+	prefix, secret, ok := splitAPIKey(plaintext)
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT id, user_id, name, prefix, hash, scopes, expiry, last_used_at, created_at
+        FROM api_keys
+        WHERE prefix = $1
+        AND (expiry IS NULL OR expiry > NOW())`
+
+	touchQuery := `
+        UPDATE api_keys
+        SET last_used_at = NOW()
+        WHERE id = $1
+        AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, prefix).Scan(
+		&key.Id,
+		&key.UserId,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		pq.Array(&key.Scopes),
+		&key.Expiry,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if subtle.ConstantTimeCompare(key.Hash, hashAPIKeySecret(secret)) != 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	_, err = m.DB.ExecContext(ctx, touchQuery, key.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `
+        SELECT id, user_id, name, prefix, hash, scopes, expiry, last_used_at, created_at
+        FROM api_keys
+        WHERE user_id = $1
+        ORDER BY created_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.Id,
+			&key.UserId,
+			&key.Name,
+			&key.Prefix,
+			&key.Hash,
+			pq.Array(&key.Scopes),
+			&key.Expiry,
+			&key.LastUsedAt,
+			&key.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Delete revokes one of the user's keys.
+func (m APIKeyModel) Delete(userID, id int64) error {
+	query := `
+        DELETE FROM api_keys
+        WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
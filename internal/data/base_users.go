@@ -2,9 +2,12 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base32"
 	"errors"
+	"marketier/internal/crypto"
 	"marketier/internal/validator"
 	"strconv"
 	"time"
@@ -16,6 +19,45 @@ var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
+// Account statuses. New accounts start out registering until their email address is
+// confirmed. Administrators can suspend and later reinstate activated accounts, users can
+// deactivate their own and come back later, and any account can be marked for deletion.
+const (
+	AccountStatusRegistering     = "REGISTERING"
+	AccountStatusActivated       = "ACTIVATED"
+	AccountStatusSuspended       = "SUSPENDED"
+	AccountStatusDeactivated     = "DEACTIVATED"
+	AccountStatusPendingDeletion = "PENDING_DELETION"
+)
+
+var AccountStatuses = []string{
+	AccountStatusRegistering,
+	AccountStatusActivated,
+	AccountStatusSuspended,
+	AccountStatusDeactivated,
+	AccountStatusPendingDeletion,
+}
+
+// accountStatusTransitions lists the statuses each status can move to. An account pending
+// deletion can only go back to being activated, when the deletion is called off.
+var accountStatusTransitions = map[string][]string{
+	AccountStatusRegistering:     {AccountStatusActivated, AccountStatusPendingDeletion},
+	AccountStatusActivated:       {AccountStatusSuspended, AccountStatusDeactivated, AccountStatusPendingDeletion},
+	AccountStatusSuspended:       {AccountStatusActivated, AccountStatusPendingDeletion},
+	AccountStatusDeactivated:     {AccountStatusActivated, AccountStatusPendingDeletion},
+	AccountStatusPendingDeletion: {AccountStatusActivated},
+}
+
+// CanTransition reports whether an account can move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, status := range accountStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
 var AnonymousUserAccount = &BaseUserAccount{}
 
 type BaseUserAccount struct {
@@ -55,6 +97,19 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// setRandom sets a password nobody knows, for accounts that have to go through a password
+// reset before they can log in with one.
+func (p *password) setRandom() error {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return err
+	}
+
+	return p.Set(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+}
+
 func (p *password) Matches(plaintextPassword string) (bool, error) {
 	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
 	if err != nil {
@@ -69,6 +124,16 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// dummyPasswordHash is checked against when there is no account for an email address,
+// so that a failed login takes as long whether or not the address is registered.
+var dummyPasswordHash = []byte("$2a$12$ySH9IQIsdwRD6zlSOHvH3OWiQtV/UIpEMvj4SWnHziehH5DZ40dQ6")
+
+// SpendPasswordCheck does the work of checking a password without an account to check it
+// against.
+func SpendPasswordCheck(plaintextPassword string) {
+	bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(plaintextPassword))
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(len(email) <= 100, "email", "must be less than 100 bytes")
@@ -102,22 +167,30 @@ func ValidateBaseUser(v *validator.Validator, baseUserAccount *BaseUserAccount)
 }
 
 type BaseUserAccountModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Keyring *crypto.Keyring
 }
 
 func (baseUserModel BaseUserAccountModel) Insert(baseUser *BaseUserAccount) error {
 	query := `
         INSERT INTO base_users (first_name, last_name, email, date_of_birth, gender, address, password, account_type) 
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        VALUES ($1, $2, $3, '', '', '', $4, $5)
         RETURNING user_id, account_creation_time, account_status, version`
 
-	args := []interface{}{baseUser.FirstName, baseUser.LastName, baseUser.Email, baseUser.DateOfBirth, baseUser.Gender, baseUser.Address, baseUser.Password.hash, baseUser.AccountType}
+	args := []interface{}{baseUser.FirstName, baseUser.LastName, baseUser.Email, baseUser.Password.hash, baseUser.AccountType}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Begin a transaction
+	tx, err := baseUserModel.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
-	err := baseUserModel.DB.QueryRowContext(ctx, query, args...).Scan(&baseUser.UserId, &baseUser.AccountCreationTime, &baseUser.AccountStatus, &baseUser.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&baseUser.UserId, &baseUser.AccountCreationTime, &baseUser.AccountStatus, &baseUser.Version)
 	if err != nil {
+		tx.Rollback()
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "base_users_email_key"`:
 			return ErrDuplicateEmail
@@ -126,7 +199,13 @@ func (baseUserModel BaseUserAccountModel) Insert(baseUser *BaseUserAccount) erro
 		}
 	}
 
-	return nil
+	err = writePersonalDetails(ctx, tx, baseUserModel.Keyring, baseUser)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (m BaseUserAccountModel) GetByEmail(email string) (*BaseUserAccount, error) {
@@ -145,9 +224,9 @@ func (m BaseUserAccountModel) GetByEmail(email string) (*BaseUserAccount, error)
 		&baseUser.FirstName,
 		&baseUser.LastName,
 		&baseUser.Email,
-		&baseUser.DateOfBirth,
-		&baseUser.Gender,
-		&baseUser.Address,
+		encryptedTime(m.Keyring, columnDateOfBirth, &baseUser.UserId, &baseUser.DateOfBirth),
+		encryptedString(m.Keyring, columnGender, &baseUser.UserId, &baseUser.Gender),
+		encryptedString(m.Keyring, columnAddress, &baseUser.UserId, &baseUser.Address),
 		&baseUser.Password.hash,
 		&baseUser.AccountCreationTime,
 		&baseUser.LastLoginTime,
@@ -171,18 +250,16 @@ func (m BaseUserAccountModel) GetByEmail(email string) (*BaseUserAccount, error)
 func (m BaseUserAccountModel) Update(baseUser *BaseUserAccount) error {
 	query := `
         UPDATE base_users
-        SET first_name = $1, last_name = $2, email = $3, address = $4, password = $5, last_login_time = $6, account_status = $7, version = version + 1
-        WHERE user_id = $8 AND version = $9
+        SET first_name = $1, last_name = $2, address = $3, password = $4, last_login_time = $5, version = version + 1
+        WHERE user_id = $6 AND version = $7
         RETURNING version`
 
 	args := []interface{}{
 		baseUser.FirstName,
 		baseUser.LastName,
-		baseUser.Email,
-		baseUser.Address,
+		encryptedString(m.Keyring, columnAddress, &baseUser.UserId, &baseUser.Address),
 		baseUser.Password.hash,
 		baseUser.LastLoginTime,
-		baseUser.AccountStatus,
 		baseUser.UserId,
 		baseUser.Version,
 	}
@@ -205,6 +282,111 @@ func (m BaseUserAccountModel) Update(baseUser *BaseUserAccount) error {
 	return nil
 }
 
+// ResetPassword saves the password the user set with a password reset token. In the same
+// transaction it uses up their reset tokens and revokes every session they have, so that
+// anyone who had taken over the account is signed out along with the old password.
+func (m BaseUserAccountModel) ResetPassword(user *BaseUserAccount, event *SecurityEvent) error {
+	query := `
+        UPDATE base_users
+        SET password = $1, version = version + 1
+        WHERE user_id = $2 AND version = $3
+        RETURNING version`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.UserId, user.Version).Scan(&user.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = deleteTokensForUser(ctx, tx, user.UserId, ScopeAuthentication, ScopeRefresh, ScopePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetStatus moves the user to a new account status, refusing any move the status doesn't
+// allow. Leaving the activated status signs the user out everywhere.
+func (m BaseUserAccountModel) SetStatus(user *BaseUserAccount, status string) error {
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = setAccountStatus(ctx, tx, user, status)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	user.AccountStatus = status
+
+	return nil
+}
+
+// setAccountStatus makes the status change for SetStatus inside the caller's transaction.
+// The update only applies if the row still has the status and version the user was read
+// with, so two changes racing each other can't skip a step. The caller sets the new status
+// on the user once the transaction commits.
+func setAccountStatus(ctx context.Context, tx *sql.Tx, user *BaseUserAccount, status string) error {
+	if !CanTransition(user.AccountStatus, status) {
+		return ErrInvalidTransition
+	}
+
+	query := `
+        UPDATE base_users
+        SET account_status = $1, version = version + 1
+        WHERE user_id = $2 AND account_status = $3 AND version = $4
+        RETURNING version`
+
+	err := tx.QueryRowContext(ctx, query, status, user.UserId, user.AccountStatus, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	if user.AccountStatus == AccountStatusActivated {
+		return deleteTokensForUser(ctx, tx, user.UserId, ScopeAuthentication, ScopeRefresh)
+	}
+
+	return nil
+}
+
 func (m BaseUserAccountModel) GetForToken(tokenScope, tokenPlaintext string) (*BaseUserAccount, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
@@ -229,9 +411,9 @@ func (m BaseUserAccountModel) GetForToken(tokenScope, tokenPlaintext string) (*B
 		&baseUser.FirstName,
 		&baseUser.LastName,
 		&baseUser.Email,
-		&baseUser.DateOfBirth,
-		&baseUser.Gender,
-		&baseUser.Address,
+		encryptedTime(m.Keyring, columnDateOfBirth, &baseUser.UserId, &baseUser.DateOfBirth),
+		encryptedString(m.Keyring, columnGender, &baseUser.UserId, &baseUser.Gender),
+		encryptedString(m.Keyring, columnAddress, &baseUser.UserId, &baseUser.Address),
 		&baseUser.Password.hash,
 		&baseUser.AccountCreationTime,
 		&baseUser.LastLoginTime,
@@ -270,9 +452,9 @@ func (baseUserAccountModel BaseUserAccountModel) GetById(id int64) (*BaseUserAcc
 		&baseUser.FirstName,
 		&baseUser.LastName,
 		&baseUser.Email,
-		&baseUser.DateOfBirth,
-		&baseUser.Gender,
-		&baseUser.Address,
+		encryptedTime(baseUserAccountModel.Keyring, columnDateOfBirth, &baseUser.UserId, &baseUser.DateOfBirth),
+		encryptedString(baseUserAccountModel.Keyring, columnGender, &baseUser.UserId, &baseUser.Gender),
+		encryptedString(baseUserAccountModel.Keyring, columnAddress, &baseUser.UserId, &baseUser.Address),
 		&baseUser.Password.hash,
 		&baseUser.AccountCreationTime,
 		&baseUser.LastLoginTime,
@@ -292,32 +474,3 @@ func (baseUserAccountModel BaseUserAccountModel) GetById(id int64) (*BaseUserAcc
 
 	return &baseUser, nil
 }
-
-func (baseUserAccountModel BaseUserAccountModel) Delete(id int64) error {
-	if id < 1 {
-		return ErrRecordNotFound
-	}
-
-	query := `
-        DELETE FROM base_users
-        WHERE id = $1`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	result, err := baseUserAccountModel.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-
-	return nil
-}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"marketier/internal/validator"
+	"time"
+)
+
+var (
+	ErrDuplicateOrder = errors.New("duplicate order id")
+)
+
+const (
+	AttributionLastClick  = "last-click"
+	AttributionFirstClick = "first-click"
+)
+
+type Conversion struct {
+	ConversionId int64     `json:"conversion_id"`
+	OrderId      string    `json:"order_id"`
+	ProductId    int64     `json:"product_id"`
+	OwnerId      int64     `json:"owner_id"`
+	MarketierId  int64     `json:"marketier_id"`
+	LinkId       int64     `json:"link_id"`
+	ClickId      int64     `json:"click_id"`
+	OrderTotal   Money     `json:"order_total"`
+	Commission   Money     `json:"commission"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func ValidateConversion(v *validator.Validator, conversion *Conversion) {
+	v.Check(conversion.OrderId != "", "order_id", "must be provided")
+	v.Check(len(conversion.OrderId) <= 250, "order_id", "must not be more than 250 bytes long")
+	v.Check(conversion.ProductId > 0, "product_id", "must be provided")
+	ValidateMoney(v, "order_total", conversion.OrderTotal)
+	v.Check(!conversion.OccurredAt.After(time.Now()), "occurred_at", "must not be in the future")
+}
+
+type ConversionModel struct {
+	DB *sql.DB
+}
+
+// FindAttributedClick finds the click on one of the product's tracking links that a sale
+// made by the visitor at occurredAt should be credited to. Only clicks inside the
+// attribution window are considered, and the model decides between the earliest and the
+// latest of them.
+func (m ConversionModel) FindAttributedClick(productId int64, ipHash []byte, occurredAt time.Time, window time.Duration, model string) (*Click, int64, error) {
+	direction := "DESC"
+	if model == AttributionFirstClick {
+		direction = "ASC"
+	}
+
+	query := fmt.Sprintf(`
+        SELECT tracking_clicks.click_id, tracking_clicks.link_id, tracking_clicks.clicked_at, tracking_links.marketier_id
+        FROM tracking_clicks
+        INNER JOIN tracking_links ON tracking_links.link_id = tracking_clicks.link_id
+        WHERE tracking_links.product_id = $1
+        AND tracking_clicks.ip_hash = $2
+        AND tracking_clicks.clicked_at BETWEEN $3 AND $4
+        ORDER BY tracking_clicks.clicked_at %s, tracking_clicks.click_id %s
+        LIMIT 1`, direction, direction)
+
+	args := []interface{}{productId, ipHash, occurredAt.Add(-window), occurredAt}
+
+	var click Click
+	var marketierId int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&click.ClickId, &click.LinkId, &click.ClickedAt, &marketierId)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, 0, ErrRecordNotFound
+		default:
+			return nil, 0, err
+		}
+	}
+
+	return &click, marketierId, nil
+}
+
+// Insert records the conversion, credits the sale to both the marketier and the product
+// owner, and accrues the marketier's commission in the ledger, all in a single
+// transaction.
+func (m ConversionModel) Insert(conversion *Conversion) error {
+	query := `
+        INSERT INTO conversions (order_id, product_id, owner_id, marketier_id, link_id, click_id, order_total, commission, currency, occurred_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING conversion_id, created_at`
+
+	args := []interface{}{
+		conversion.OrderId,
+		conversion.ProductId,
+		conversion.OwnerId,
+		conversion.MarketierId,
+		conversion.LinkId,
+		conversion.ClickId,
+		conversion.OrderTotal.Amount,
+		conversion.Commission.Amount,
+		conversion.OrderTotal.Currency,
+		conversion.OccurredAt,
+	}
+
+	marketierQuery := `
+        UPDATE marketiers
+        SET sales_generated = sales_generated + 1
+        WHERE user_id = $1`
+
+	productOwnerQuery := `
+        UPDATE product_owners
+        SET sales_generated = sales_generated + 1
+        WHERE user_id = $1`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&conversion.ConversionId, &conversion.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "conversions_owner_id_order_id_key"`:
+			return ErrDuplicateOrder
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, marketierQuery, conversion.MarketierId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, productOwnerQuery, conversion.OwnerId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if conversion.Commission.Amount > 0 {
+		entry := &JournalEntry{
+			Description:  fmt.Sprintf("Commission accrued on order %s", conversion.OrderId),
+			ConversionId: &conversion.ConversionId,
+			Postings: []Posting{
+				{AccountKind: AccountOwnerReceivable, UserId: conversion.OwnerId, Amount: conversion.Commission},
+				{AccountKind: AccountMarketierPayable, UserId: conversion.MarketierId, Amount: Money{Amount: -conversion.Commission.Amount, Currency: conversion.Commission.Currency}},
+			},
+		}
+
+		err = postJournalEntry(ctx, tx, entry)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,363 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"marketier/internal/crypto"
+)
+
+// DataExport is the latest archive of a user's data, kept on disk under FileName until it
+// expires.
+type DataExport struct {
+	UserId    int64     `json:"-"`
+	FileName  string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+// ExportSection is one part of a user's data, as a JSON array of rows.
+type ExportSection struct {
+	Name string
+	Rows json.RawMessage
+}
+
+// UserData is everything held about a user, along with the proposals and products whose
+// images belong in the export.
+type UserData struct {
+	Sections    []ExportSection
+	ProposalIds []int64
+	ProductIds  []int64
+}
+
+// exportQueries pick out everything tied to a user, by section name. Secrets such as
+// password and key hashes are left out, and so are the encrypted personal details, which
+// Collect decrypts into a section of their own.
+var exportQueries = []struct {
+	name  string
+	query string
+}{
+	{"account", `
+        SELECT user_id, first_name, last_name, email, account_creation_time, last_login_time, account_status, account_type
+        FROM base_users
+        WHERE user_id = $1`},
+	{"marketier_profile", `SELECT * FROM marketiers WHERE user_id = $1`},
+	{"product_owner_profile", `SELECT * FROM product_owners WHERE user_id = $1`},
+	{"permissions", `
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+        WHERE users_permissions.user_id = $1`},
+	{"reviews", `SELECT * FROM reviews WHERE user_id = $1`},
+	{"threads", `
+        SELECT threads.thread_id, threads.subject, threads.created_at
+        FROM threads
+        INNER JOIN thread_participants ON thread_participants.thread_id = threads.thread_id
+        WHERE thread_participants.user_id = $1`},
+	{"messages", `SELECT message_id, thread_id, body, created_at FROM messages WHERE sender_id = $1`},
+	{"proposals", `SELECT * FROM proposals WHERE marketier_id = $1`},
+	{"proposal_history", `SELECT * FROM proposal_history WHERE actor_id = $1`},
+	{"products", `SELECT * FROM products WHERE owner_id = $1`},
+	{"tracking_links", `SELECT * FROM tracking_links WHERE marketier_id = $1`},
+	{"tier_history", `SELECT * FROM tier_history WHERE marketier_id = $1`},
+	{"conversions", `SELECT * FROM conversions WHERE owner_id = $1 OR marketier_id = $1`},
+	{"ledger", `
+        SELECT ledger_postings.posting_id, journal_entries.entry_id, journal_entries.description, journal_entries.conversion_id,
+               ledger_accounts.kind, ledger_postings.amount, ledger_postings.currency, journal_entries.created_at
+        FROM ledger_postings
+        INNER JOIN ledger_accounts ON ledger_accounts.account_id = ledger_postings.account_id
+        INNER JOIN journal_entries ON journal_entries.entry_id = ledger_postings.entry_id
+        WHERE ledger_accounts.user_id = $1`},
+	{"sessions", `SELECT id, created_at, last_used_at, ip, user_agent FROM token_families WHERE user_id = $1`},
+	{"identities", `SELECT provider, subject, email, created_at, last_login_at FROM user_identities WHERE user_id = $1`},
+	{"api_keys", `SELECT name, prefix, scopes, expiry, last_used_at, created_at FROM api_keys WHERE user_id = $1`},
+	{"two_factor", `SELECT confirmed_at, created_at FROM user_mfa WHERE user_id = $1`},
+	{"known_devices", `SELECT first_seen_at, last_seen_at FROM known_devices WHERE user_id = $1`},
+	{"security_events", `SELECT event_type, ip, user_agent, details, created_at FROM security_events WHERE user_id = $1`},
+	{"admin_actions", `SELECT action, reason, created_at FROM admin_audit WHERE target_user_id = $1`},
+}
+
+type DataExportModel struct {
+	DB      *sql.DB
+	Keyring *crypto.Keyring
+}
+
+// Collect reads everything held about the user. It all comes from one snapshot of the
+// database, so the sections agree with each other.
+func (m DataExportModel) Collect(userID int64) (*UserData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Begin a transaction
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	userData := &UserData{}
+
+	for _, section := range exportQueries {
+		query := `SELECT COALESCE(json_agg(section), '[]'::json) FROM (` + section.query + `) AS section`
+
+		var rows []byte
+
+		err = tx.QueryRowContext(ctx, query, userID).Scan(&rows)
+		if err != nil {
+			return nil, err
+		}
+
+		userData.Sections = append(userData.Sections, ExportSection{Name: section.name, Rows: rows})
+	}
+
+	personalDetails, err := m.collectPersonalDetails(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	userData.Sections = append(userData.Sections, personalDetails)
+
+	userData.ProposalIds, err = queryIds(ctx, tx, `SELECT proposal_id FROM proposals WHERE marketier_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	userData.ProductIds, err = queryIds(ctx, tx, `SELECT product_id FROM products WHERE owner_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return userData, nil
+}
+
+// collectPersonalDetails decrypts the user's personal details for the export.
+func (m DataExportModel) collectPersonalDetails(ctx context.Context, tx *sql.Tx, userID int64) (ExportSection, error) {
+	query := `
+        SELECT date_of_birth, gender, address
+        FROM base_users
+        WHERE user_id = $1`
+
+	var details struct {
+		DateOfBirth time.Time `json:"date_of_birth"`
+		Gender      string    `json:"gender"`
+		Address     string    `json:"address"`
+	}
+
+	err := tx.QueryRowContext(ctx, query, userID).Scan(
+		encryptedTime(m.Keyring, columnDateOfBirth, &userID, &details.DateOfBirth),
+		encryptedString(m.Keyring, columnGender, &userID, &details.Gender),
+		encryptedString(m.Keyring, columnAddress, &userID, &details.Address),
+	)
+	if err != nil {
+		return ExportSection{}, err
+	}
+
+	rows, err := json.Marshal([]interface{}{details})
+	if err != nil {
+		return ExportSection{}, err
+	}
+
+	return ExportSection{Name: "personal_details", Rows: rows}, nil
+}
+
+// queryIds runs a query that returns a single column of ids.
+func queryIds(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Claim records that the user has asked for an export, unless they already asked within
+// the interval. It reports whether the request may go ahead, and is safe to call for the
+// same user from several requests at once.
+func (m DataExportModel) Claim(userID int64, interval time.Duration) (bool, error) {
+	query := `
+        INSERT INTO data_export_requests (user_id)
+        VALUES ($1)
+        ON CONFLICT (user_id) DO UPDATE
+        SET requested_at = NOW()
+        WHERE data_export_requests.requested_at < NOW() - make_interval(secs => $2)
+        RETURNING user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, interval.Seconds()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// Insert records a new export for the user and returns a token for downloading it. The
+// export replaces the user's previous one, whose file name is returned so that the caller
+// can remove it; it is empty if there wasn't one.
+func (m DataExportModel) Insert(export *DataExport) (*Token, string, error) {
+	previousQuery := `
+        SELECT file_name
+        FROM data_exports
+        WHERE user_id = $1
+        FOR UPDATE`
+
+	query := `
+        INSERT INTO data_exports (user_id, file_name, expiry)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id) DO UPDATE
+        SET file_name = EXCLUDED.file_name, created_at = NOW(), expiry = EXCLUDED.expiry
+        RETURNING created_at`
+
+	tokenQuery := `
+        INSERT INTO tokens (hash, user_id, expiry, scope)
+        VALUES ($1, $2, $3, $4)`
+
+	token, err := generateToken(export.UserId, time.Until(export.Expiry), ScopeDataExport)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	var previous string
+
+	err = tx.QueryRowContext(ctx, previousQuery, export.UserId).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	err = tx.QueryRowContext(ctx, query, export.UserId, export.FileName, export.Expiry).Scan(&export.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	err = deleteTokensForUser(ctx, tx, export.UserId, ScopeDataExport)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	_, err = tx.ExecContext(ctx, tokenQuery, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		tx.Rollback()
+		return nil, "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, previous, nil
+}
+
+// GetForToken returns the unexpired export that the download token was issued for.
+func (m DataExportModel) GetForToken(tokenPlaintext string) (*DataExport, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+        SELECT data_exports.user_id, data_exports.file_name, data_exports.created_at, data_exports.expiry
+        FROM data_exports
+        INNER JOIN tokens ON tokens.user_id = data_exports.user_id
+        WHERE tokens.hash = $1
+        AND tokens.scope = $2
+        AND tokens.expiry > NOW()
+        AND data_exports.expiry > NOW()`
+
+	var export DataExport
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash[:], ScopeDataExport).Scan(
+		&export.UserId,
+		&export.FileName,
+		&export.CreatedAt,
+		&export.Expiry,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &export, nil
+}
+
+// DeleteExpired removes the exports that have expired and returns their file names, so
+// that the caller can remove the files too.
+func (m DataExportModel) DeleteExpired() ([]string, error) {
+	query := `
+        DELETE FROM data_exports
+        WHERE expiry < NOW()
+        RETURNING file_name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fileNames := []string{}
+
+	for rows.Next() {
+		var fileName string
+
+		err := rows.Scan(&fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		fileNames = append(fileNames, fileName)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fileNames, nil
+}
@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type EmailChange struct {
+	UserId    int64     `json:"-"`
+	NewEmail  string    `json:"new_email"`
+	CreatedAt time.Time `json:"created_at"`
+	Expiry    time.Time `json:"expiry"`
+}
+
+type EmailChangeModel struct {
+	DB *sql.DB
+}
+
+// Request records that the user wants to move to a new email address, replacing any
+// change they already had pending along with the tokens sent out for it. It returns the
+// token confirming the change, for the new address, and the token cancelling it, for the
+// old one, both lasting until the change expires.
+func (m EmailChangeModel) Request(change *EmailChange) (*Token, *Token, error) {
+	existsQuery := `
+        SELECT EXISTS (SELECT 1 FROM base_users WHERE email = $1)`
+
+	query := `
+        INSERT INTO email_changes (user_id, new_email, expiry)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (user_id) DO UPDATE
+        SET new_email = EXCLUDED.new_email, created_at = NOW(), expiry = EXCLUDED.expiry
+        RETURNING created_at`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err = tx.QueryRowContext(ctx, existsQuery, change.NewEmail).Scan(&exists)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if exists {
+		tx.Rollback()
+		return nil, nil, ErrDuplicateEmail
+	}
+
+	err = tx.QueryRowContext(ctx, query, change.UserId, change.NewEmail, change.Expiry).Scan(&change.CreatedAt)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	err = deleteTokensForUser(ctx, tx, change.UserId, ScopeEmailChange, ScopeEmailChangeCancel)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	ttl := time.Until(change.Expiry)
+
+	token, err := insertToken(ctx, tx, change.UserId, ttl, ScopeEmailChange)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	cancelToken, err := insertToken(ctx, tx, change.UserId, ttl, ScopeEmailChangeCancel)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, cancelToken, nil
+}
+
+// Confirm moves the user to their pending email address. Every token the user holds is
+// revoked, logging them out everywhere, since anything sent to or issued under the old
+// address should no longer work.
+func (m EmailChangeModel) Confirm(user *BaseUserAccount) error {
+	query := `
+        DELETE FROM email_changes
+        WHERE user_id = $1 AND expiry > NOW()
+        RETURNING new_email`
+
+	updateQuery := `
+        UPDATE base_users
+        SET email = $1, version = version + 1
+        WHERE user_id = $2
+        RETURNING version`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	var newEmail string
+
+	err = tx.QueryRowContext(ctx, query, user.UserId).Scan(&newEmail)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, updateQuery, newEmail, user.UserId).Scan(&user.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "base_users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	err = deleteTokensForUser(ctx, tx, user.UserId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	user.Email = newEmail
+
+	return nil
+}
+
+// Cancel drops the user's pending email change and the tokens sent out for it.
+func (m EmailChangeModel) Cancel(userID int64) error {
+	query := `
+        DELETE FROM email_changes
+        WHERE user_id = $1`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	result, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return ErrRecordNotFound
+	}
+
+	err = deleteTokensForUser(ctx, tx, userID, ScopeEmailChange, ScopeEmailChangeCancel)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+
+	"marketier/internal/crypto"
+)
+
+var (
+	ErrDuplicateIdentity = errors.New("duplicate identity")
+)
+
+// Identity links an account at an external OpenID Connect provider to a user.
+type Identity struct {
+	Id          int64      `json:"id"`
+	UserId      int64      `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"-"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+type IdentityModel struct {
+	DB      *sql.DB
+	Keyring *crypto.Keyring
+}
+
+// NewLoginState starts a sign-in with the provider, returning the state to send it and
+// the nonce it must put in the ID token. The state can be used once, within the ttl.
+func (m IdentityModel) NewLoginState(provider string, ttl time.Duration) (string, string, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	query := `
+        INSERT INTO oidc_login_states (state_hash, provider, nonce, expiry)
+        VALUES ($1, $2, $3, $4)`
+
+	stateHash := sha256.Sum256([]byte(state))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, stateHash[:], provider, nonce, time.Now().Add(ttl))
+	if err != nil {
+		return "", "", err
+	}
+
+	return state, nonce, nil
+}
+
+// ConsumeLoginState ends the sign-in started with the state and returns its nonce. An
+// unknown or expired state, or one started with another provider, is not found.
+func (m IdentityModel) ConsumeLoginState(provider, state string) (string, error) {
+	query := `
+        DELETE FROM oidc_login_states
+        WHERE state_hash = $1
+        RETURNING provider, nonce, expiry > NOW()`
+
+	stateHash := sha256.Sum256([]byte(state))
+
+	var stateProvider, nonce string
+	var current bool
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, stateHash[:]).Scan(&stateProvider, &nonce, &current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+
+	if stateProvider != provider || !current {
+		return "", ErrRecordNotFound
+	}
+
+	return nonce, nil
+}
+
+// DeleteExpiredLoginStates removes sign-ins that were started but never finished.
+func (m IdentityModel) DeleteExpiredLoginStates() error {
+	query := `
+        DELETE FROM oidc_login_states
+        WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query)
+	return err
+}
+
+// GetUserID returns the user the provider's subject is linked to, and records that they
+// have just signed in with it.
+func (m IdentityModel) GetUserID(provider, subject string) (int64, error) {
+	query := `
+        UPDATE user_identities
+        SET last_login_at = NOW()
+        WHERE provider = $1 AND subject = $2
+        RETURNING user_id`
+
+	var userID int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+func insertIdentity(ctx context.Context, tx *sql.Tx, identity *Identity) error {
+	query := `
+        INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+        VALUES ($1, $2, $3, $4, NOW())
+        RETURNING id, created_at, last_login_at`
+
+	args := []interface{}{identity.UserId, identity.Provider, identity.Subject, identity.Email}
+
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&identity.Id, &identity.CreatedAt, &identity.LastLoginAt)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_provider_subject_key"`:
+			return ErrDuplicateIdentity
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Link connects the identity to an existing user. A user who never confirmed their email
+// address is activated, since the provider has just confirmed it, and their password is
+// replaced because whoever chose it may not own the address.
+func (m IdentityModel) Link(user *BaseUserAccount, identity *Identity) error {
+	claimQuery := `
+        UPDATE base_users
+        SET account_status = $1, password = $2, version = version + 1
+        WHERE user_id = $3 AND version = $4
+        RETURNING version`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	if user.AccountStatus == AccountStatusRegistering {
+		err = user.Password.setRandom()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		args := []interface{}{AccountStatusActivated, user.Password.hash, user.UserId, user.Version}
+
+		err = tx.QueryRowContext(ctx, claimQuery, args...).Scan(&user.Version)
+		if err != nil {
+			tx.Rollback()
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		err = deleteTokensForUser(ctx, tx, user.UserId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		user.AccountStatus = AccountStatusActivated
+	}
+
+	identity.UserId = user.UserId
+
+	err = insertIdentity(ctx, tx, identity)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateUser registers a shopper for the identity. The provider has confirmed the email
+// address, so the account starts out activated, and it gets a random password that can
+// be changed with a password reset. The profile fields the provider doesn't share are
+// left empty for the user to fill in.
+func (m IdentityModel) CreateUser(user *BaseUserAccount, identity *Identity) error {
+	query := `
+        INSERT INTO base_users (first_name, last_name, email, date_of_birth, gender, address, password, account_type, account_status)
+        VALUES ($1, $2, $3, '', '', '', $4, $5, $6)
+        RETURNING user_id, account_creation_time, account_status, version`
+
+	err := user.Password.setRandom()
+	if err != nil {
+		return err
+	}
+
+	user.AccountType = 1
+
+	args := []interface{}{user.FirstName, user.LastName, user.Email, user.Password.hash, user.AccountType, AccountStatusActivated}
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&user.UserId, &user.AccountCreationTime, &user.AccountStatus, &user.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "base_users_email_key"`:
+			return ErrDuplicateEmail
+		default:
+			return err
+		}
+	}
+
+	err = writePersonalDetails(ctx, tx, m.Keyring, user)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	identity.UserId = user.UserId
+
+	err = insertIdentity(ctx, tx, identity)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func randomString() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
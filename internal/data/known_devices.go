@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type KnownDeviceModel struct {
+	DB *sql.DB
+}
+
+// Remember records that the user has logged in from the device with the fingerprint, and
+// reports whether that's a device they haven't used before. A user's first device doesn't
+// count as new, since there's nothing to compare it against: that's the one they signed
+// up on, or the first login since devices started being remembered.
+func (m KnownDeviceModel) Remember(userID int64, fingerprint []byte) (bool, error) {
+	insertQuery := `
+        INSERT INTO known_devices (user_id, fingerprint)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id, fingerprint) DO NOTHING`
+
+	updateQuery := `
+        UPDATE known_devices
+        SET last_seen_at = NOW()
+        WHERE user_id = $1 AND fingerprint = $2`
+
+	countQuery := `
+        SELECT count(*)
+        FROM known_devices
+        WHERE user_id = $1`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	result, err := tx.ExecContext(ctx, insertQuery, userID, fingerprint)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if rowsAffected == 0 {
+		_, err = tx.ExecContext(ctx, updateQuery, userID, fingerprint)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+
+		return false, tx.Commit()
+	}
+
+	var devices int
+
+	err = tx.QueryRowContext(ctx, countQuery, userID).Scan(&devices)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return devices > 1, nil
+}
+
+// ReportLogin locks the user out after they've said a login wasn't them. Their password is
+// replaced with one nobody knows, every token and API key they hold is revoked, which
+// signs them out everywhere, and their devices are forgotten. Two-factor authentication
+// is turned off as well, since whoever logged in may have set it up with their own
+// authenticator app to keep the user out. It returns a password reset token, for the
+// caller to send them so they can get back in.
+func (m KnownDeviceModel) ReportLogin(user *BaseUserAccount, resetTTL time.Duration, event *SecurityEvent) (*Token, error) {
+	err := user.Password.setRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+        UPDATE base_users
+        SET password = $1, version = version + 1
+        WHERE user_id = $2 AND version = $3
+        RETURNING version`
+
+	queries := []string{
+		`DELETE FROM known_devices WHERE user_id = $1`,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+		`DELETE FROM user_mfa WHERE user_id = $1`,
+	}
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, user.Password.hash, user.UserId, user.Version).Scan(&user.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	err = deleteTokensForUser(ctx, tx, user.UserId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, user.UserId)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	token, err := insertToken(ctx, tx, user.UserId, resetTTL, ScopePasswordReset)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,213 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrUnbalancedEntry = errors.New("journal entry postings do not balance")
+)
+
+// Ledger account kinds. Postings store debits as positive amounts and credits as negative
+// amounts, so the postings of every journal entry sum to zero.
+const (
+	AccountMarketierPayable = "marketier_payable"
+	AccountOwnerReceivable  = "owner_receivable"
+)
+
+// creditNormal reports whether increases to the account kind are recorded as credits, in
+// which case balances are reported with the sign flipped.
+func creditNormal(kind string) bool {
+	return kind == AccountMarketierPayable
+}
+
+type JournalEntry struct {
+	EntryId      int64     `json:"entry_id"`
+	Description  string    `json:"description"`
+	ConversionId *int64    `json:"conversion_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	Postings     []Posting `json:"postings"`
+}
+
+type Posting struct {
+	PostingId   int64  `json:"posting_id"`
+	AccountKind string `json:"account_kind"`
+	UserId      int64  `json:"user_id"`
+	Amount      Money  `json:"amount"`
+}
+
+type StatementLine struct {
+	PostingId    int64     `json:"posting_id"`
+	EntryId      int64     `json:"entry_id"`
+	Description  string    `json:"description"`
+	ConversionId *int64    `json:"conversion_id,omitempty"`
+	Amount       Money     `json:"amount"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// postJournalEntry writes the entry and its postings using the caller's transaction,
+// creating any ledger accounts that don't exist yet.
+func postJournalEntry(ctx context.Context, tx *sql.Tx, entry *JournalEntry) error {
+	totals := make(map[string]int64)
+	for _, posting := range entry.Postings {
+		totals[posting.Amount.Currency] += posting.Amount.Amount
+	}
+
+	for _, total := range totals {
+		if total != 0 {
+			return ErrUnbalancedEntry
+		}
+	}
+
+	entryQuery := `
+        INSERT INTO journal_entries (description, conversion_id)
+        VALUES ($1, $2)
+        RETURNING entry_id, created_at`
+
+	accountQuery := `
+        INSERT INTO ledger_accounts (kind, user_id, currency)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (kind, user_id, currency) DO UPDATE SET kind = EXCLUDED.kind
+        RETURNING account_id`
+
+	postingQuery := `
+        INSERT INTO ledger_postings (entry_id, account_id, amount, currency)
+        VALUES ($1, $2, $3, $4)
+        RETURNING posting_id`
+
+	err := tx.QueryRowContext(ctx, entryQuery, entry.Description, entry.ConversionId).Scan(&entry.EntryId, &entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range entry.Postings {
+		posting := &entry.Postings[i]
+
+		var accountId int64
+
+		err = tx.QueryRowContext(ctx, accountQuery, posting.AccountKind, posting.UserId, posting.Amount.Currency).Scan(&accountId)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, postingQuery, entry.EntryId, accountId, posting.Amount.Amount, posting.Amount.Currency).Scan(&posting.PostingId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type LedgerModel struct {
+	DB *sql.DB
+}
+
+// Balance derives the balance of the user's account of the given kind from its postings,
+// with one entry per currency the account has been used in.
+func (m LedgerModel) Balance(kind string, userId int64) ([]Money, error) {
+	query := `
+        SELECT ledger_accounts.currency, COALESCE(SUM(ledger_postings.amount), 0)
+        FROM ledger_accounts
+        LEFT JOIN ledger_postings ON ledger_postings.account_id = ledger_accounts.account_id
+        WHERE ledger_accounts.kind = $1
+        AND ledger_accounts.user_id = $2
+        GROUP BY ledger_accounts.currency
+        ORDER BY ledger_accounts.currency`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, kind, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	balances := []Money{}
+
+	for rows.Next() {
+		var balance Money
+
+		err := rows.Scan(&balance.Currency, &balance.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		if creditNormal(kind) {
+			balance.Amount = -balance.Amount
+		}
+
+		balances = append(balances, balance)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func (m LedgerModel) GetStatement(kind string, userId int64, filters Filters) ([]*StatementLine, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), ledger_postings.posting_id, journal_entries.entry_id, journal_entries.description, journal_entries.conversion_id,
+            ledger_postings.amount AS amount, ledger_postings.currency, journal_entries.created_at AS created_at
+        FROM ledger_postings
+        INNER JOIN ledger_accounts ON ledger_accounts.account_id = ledger_postings.account_id
+        INNER JOIN journal_entries ON journal_entries.entry_id = ledger_postings.entry_id
+        WHERE ledger_accounts.kind = $1
+        AND ledger_accounts.user_id = $2
+        ORDER BY %s %s, posting_id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{kind, userId, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	lines := []*StatementLine{}
+
+	for rows.Next() {
+		var line StatementLine
+
+		err := rows.Scan(
+			&totalRecords,
+			&line.PostingId,
+			&line.EntryId,
+			&line.Description,
+			&line.ConversionId,
+			&line.Amount.Amount,
+			&line.Amount.Currency,
+			&line.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		if creditNormal(kind) {
+			line.Amount.Amount = -line.Amount.Amount
+		}
+
+		lines = append(lines, &line)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return lines, metadata, nil
+}
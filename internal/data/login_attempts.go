@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const (
+	loginAttemptEmail = "email"
+	loginAttemptIP    = "ip"
+)
+
+// LoginPolicy decides how long a run of failed logins blocks further attempts. The first
+// few failures are free, after which each one doubles the wait, and reaching the maximum
+// locks the email address or IP address out for the lockout duration. Failures are
+// forgotten once a lockout duration has passed without another one.
+type LoginPolicy struct {
+	FreeAttempts    int
+	BackoffBase     time.Duration
+	MaxFailures     int
+	MaxIPFailures   int
+	LockoutDuration time.Duration
+}
+
+// Delay returns how long to block logins after the given number of consecutive failures.
+func (p LoginPolicy) Delay(failures, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return p.LockoutDuration
+	}
+
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	delay := p.BackoffBase
+	for i := p.FreeAttempts + 1; i < failures && delay < p.LockoutDuration; i++ {
+		delay *= 2
+	}
+
+	if delay > p.LockoutDuration {
+		delay = p.LockoutDuration
+	}
+
+	return delay
+}
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// Blocked returns how much longer logins for the email address from the IP address are
+// blocked for, or 0 if they are allowed.
+func (m LoginAttemptModel) Blocked(email, ip string) (time.Duration, error) {
+	query := `
+        SELECT MAX(blocked_until)
+        FROM login_attempts
+        WHERE ((kind = $1 AND key = $2) OR (kind = $3 AND key = $4))
+        AND blocked_until > NOW()`
+
+	var blockedUntil *time.Time
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, loginAttemptEmail, email, loginAttemptIP, ip).Scan(&blockedUntil)
+	if err != nil {
+		return 0, err
+	}
+
+	if blockedUntil == nil {
+		return 0, nil
+	}
+
+	return time.Until(*blockedUntil), nil
+}
+
+// RecordFailure counts a failed login against both the email address and the IP address
+// and blocks them according to the policy. It reports whether this failure is the one
+// that locked the email address out, so that the owner is only told once.
+func (m LoginAttemptModel) RecordFailure(email, ip string, policy LoginPolicy) (bool, error) {
+	query := `
+        INSERT INTO login_attempts (kind, key, failures, last_failure_at)
+        VALUES ($1, $2, 1, NOW())
+        ON CONFLICT (kind, key) DO UPDATE
+        SET failures = CASE
+                WHEN login_attempts.last_failure_at < NOW() - make_interval(secs => $3) THEN 1
+                ELSE login_attempts.failures + 1
+            END,
+            last_failure_at = NOW()
+        RETURNING failures`
+
+	blockQuery := `
+        UPDATE login_attempts
+        SET blocked_until = NOW() + make_interval(secs => $3)
+        WHERE kind = $1 AND key = $2`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	locked := false
+
+	keys := []struct {
+		kind        string
+		key         string
+		maxFailures int
+	}{
+		{loginAttemptEmail, email, policy.MaxFailures},
+		{loginAttemptIP, ip, policy.MaxIPFailures},
+	}
+
+	for _, k := range keys {
+		var failures int
+
+		err = tx.QueryRowContext(ctx, query, k.kind, k.key, policy.LockoutDuration.Seconds()).Scan(&failures)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+
+		delay := policy.Delay(failures, k.maxFailures)
+		if delay == 0 {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, blockQuery, k.kind, k.key, delay.Seconds())
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+
+		if k.kind == loginAttemptEmail && failures == k.maxFailures {
+			locked = true
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// Reset clears the failed logins counted against the email address, lifting any lockout.
+// Failures counted against IP addresses are left to expire on their own.
+func (m LoginAttemptModel) Reset(email string) error {
+	query := `
+        DELETE FROM login_attempts
+        WHERE kind = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, loginAttemptEmail, email)
+	return err
+}
+
+// DeleteStale removes the rows for failures that have been forgotten and no longer block
+// anything.
+func (m LoginAttemptModel) DeleteStale(policy LoginPolicy) error {
+	query := `
+        DELETE FROM login_attempts
+        WHERE last_failure_at < NOW() - make_interval(secs => $1)
+        AND (blocked_until IS NULL OR blocked_until < NOW())`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, policy.LockoutDuration.Seconds())
+	return err
+}
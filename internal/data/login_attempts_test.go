@@ -0,0 +1,56 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyDelay(t *testing.T) {
+	policy := LoginPolicy{
+		FreeAttempts:    3,
+		BackoffBase:     time.Second,
+		MaxFailures:     10,
+		MaxIPFailures:   50,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	// A short lockout, so that the backoff reaches it before the maximum does.
+	capped := LoginPolicy{
+		FreeAttempts:    0,
+		BackoffBase:     time.Minute,
+		MaxFailures:     20,
+		LockoutDuration: 5 * time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		policy      LoginPolicy
+		failures    int
+		maxFailures int
+		want        time.Duration
+	}{
+		{"no failures", policy, 0, policy.MaxFailures, 0},
+		{"first free attempt", policy, 1, policy.MaxFailures, 0},
+		{"last free attempt", policy, 3, policy.MaxFailures, 0},
+		{"first backoff", policy, 4, policy.MaxFailures, time.Second},
+		{"backoff doubles", policy, 5, policy.MaxFailures, 2 * time.Second},
+		{"backoff keeps doubling", policy, 9, policy.MaxFailures, 32 * time.Second},
+		{"maximum locks out", policy, 10, policy.MaxFailures, 15 * time.Minute},
+		{"past the maximum", policy, 11, policy.MaxFailures, 15 * time.Minute},
+		{"ip below its own maximum", policy, 10, policy.MaxIPFailures, 64 * time.Second},
+		{"ip maximum locks out", policy, 50, policy.MaxIPFailures, 15 * time.Minute},
+		{"no free attempts", capped, 1, capped.MaxFailures, time.Minute},
+		{"backoff below the lockout", capped, 3, capped.MaxFailures, 4 * time.Minute},
+		{"backoff capped at the lockout", capped, 4, capped.MaxFailures, 5 * time.Minute},
+		{"backoff stays capped", capped, 19, capped.MaxFailures, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Delay(tt.failures, tt.maxFailures)
+			if got != tt.want {
+				t.Errorf("Delay(%d, %d) = %s, want %s", tt.failures, tt.maxFailures, got, tt.want)
+			}
+		})
+	}
+}
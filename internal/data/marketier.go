@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"marketier/internal/crypto"
 	"marketier/internal/validator"
 	"time"
 )
@@ -18,16 +19,17 @@ type MarketierUserAccount struct {
 }
 
 type MarketierAccountModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Keyring *crypto.Keyring
 }
 
 func (marketierUserModel MarketierAccountModel) Insert(marketierUser *MarketierUserAccount) error {
 	baseQuery := `
         INSERT INTO base_users (first_name, last_name, email, date_of_birth, gender, address, password, account_type) 
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        VALUES ($1, $2, $3, '', '', '', $4, $5)
         RETURNING user_id, account_creation_time, account_status, version`
 
-	baseArgs := []interface{}{marketierUser.BaseUserAccount.FirstName, marketierUser.BaseUserAccount.LastName, marketierUser.BaseUserAccount.Email, marketierUser.BaseUserAccount.DateOfBirth, marketierUser.BaseUserAccount.Gender, marketierUser.BaseUserAccount.Address, marketierUser.BaseUserAccount.Password.hash, marketierUser.BaseUserAccount.AccountType}
+	baseArgs := []interface{}{marketierUser.BaseUserAccount.FirstName, marketierUser.BaseUserAccount.LastName, marketierUser.BaseUserAccount.Email, marketierUser.BaseUserAccount.Password.hash, marketierUser.BaseUserAccount.AccountType}
 
 	marketierQuery := `
 	INSERT INTO marketiers (user_id, display_name, about, sales_generated, tier) 
@@ -56,6 +58,12 @@ func (marketierUserModel MarketierAccountModel) Insert(marketierUser *MarketierU
 		}
 	}
 
+	err = writePersonalDetails(ctx, tx, marketierUserModel.Keyring, &marketierUser.BaseUserAccount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	updatedMarketierArgs := append([]interface{}{marketierUser.BaseUserAccount.UserId}, marketierArgs...)
 
 	// Execute the second insertion
@@ -89,9 +97,9 @@ func (m MarketierAccountModel) GetByEmail(email string) (*MarketierUserAccount,
 		&marketier.BaseUserAccount.FirstName,
 		&marketier.BaseUserAccount.LastName,
 		&marketier.BaseUserAccount.Email,
-		&marketier.BaseUserAccount.DateOfBirth,
-		&marketier.BaseUserAccount.Gender,
-		&marketier.BaseUserAccount.Address,
+		encryptedTime(m.Keyring, columnDateOfBirth, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.DateOfBirth),
+		encryptedString(m.Keyring, columnGender, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Gender),
+		encryptedString(m.Keyring, columnAddress, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Address),
 		&marketier.BaseUserAccount.Password.hash,
 		&marketier.BaseUserAccount.AccountCreationTime,
 		&marketier.BaseUserAccount.LastLoginTime,
@@ -150,32 +158,30 @@ type MarketierUserAccount struct {
 func (marketierUserModel MarketierAccountModel) Update(marketier *MarketierUserAccount) error {
 	baseQuery := `
         UPDATE base_users
-        SET first_name = $1, last_name = $2, email = $3, address = $4, password = $5, last_login_time = $6, account_status = $7, version = version + 1
-        WHERE user_id = $8 AND version = $9
+        SET first_name = $1, last_name = $2, address = $3, password = $4, last_login_time = $5, version = version + 1
+        WHERE user_id = $6 AND version = $7
         RETURNING version`
 
 	baseArgs := []interface{}{
 		marketier.BaseUserAccount.FirstName,
 		marketier.BaseUserAccount.LastName,
-		marketier.BaseUserAccount.Email,
-		marketier.BaseUserAccount.Address,
+		encryptedString(marketierUserModel.Keyring, columnAddress, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Address),
 		marketier.BaseUserAccount.Password.hash,
 		marketier.BaseUserAccount.LastLoginTime,
-		marketier.BaseUserAccount.AccountStatus,
 		marketier.BaseUserAccount.UserId,
 		marketier.BaseUserAccount.Version,
 	}
 
+	// Sales generated and the tier are kept up to date by conversions and the tier
+	// recalculation, so they aren't written back from what may be a stale read.
 	marketierQuery := `
 		UPDATE marketiers
-		SET display_name = $1, about = $2, sales_generated = $3, tier = $4
-		WHERE user_id = $5`
+		SET display_name = $1, about = $2
+		WHERE user_id = $3`
 
 	marketierArgs := []interface{}{
 		marketier.DisplayName,
 		marketier.About,
-		marketier.SalesGenerated,
-		marketier.Tier,
 		marketier.BaseUserAccount.UserId,
 	}
 
@@ -195,6 +201,8 @@ func (marketierUserModel MarketierAccountModel) Update(marketier *MarketierUserA
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "base_users_email_key"`:
 			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -239,9 +247,9 @@ func (marketierUserModel MarketierAccountModel) GetForToken(tokenScope, tokenPla
 		&marketier.BaseUserAccount.FirstName,
 		&marketier.BaseUserAccount.LastName,
 		&marketier.BaseUserAccount.Email,
-		&marketier.BaseUserAccount.DateOfBirth,
-		&marketier.BaseUserAccount.Gender,
-		&marketier.BaseUserAccount.Address,
+		encryptedTime(marketierUserModel.Keyring, columnDateOfBirth, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.DateOfBirth),
+		encryptedString(marketierUserModel.Keyring, columnGender, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Gender),
+		encryptedString(marketierUserModel.Keyring, columnAddress, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Address),
 		&marketier.BaseUserAccount.Password.hash,
 		&marketier.BaseUserAccount.AccountCreationTime,
 		&marketier.BaseUserAccount.LastLoginTime,
@@ -283,8 +291,8 @@ func (marketierUserModel MarketierAccountModel) GetById(id int64) (*MarketierUse
 	}
 
 	query := `
-	SELECT base_user.user_id, first_name, last_name, email, date_of_birth, gender, address, password, account_creation_time, last_login_time, account_status, version, account_type, display_name, about, sales_generated, tier
-	FROM base_users INNER JOIN marketier ON base_users.user_id = marketiers.user_id WHERE users.user_id = $1`
+	SELECT base_users.user_id, first_name, last_name, email, date_of_birth, gender, address, password, account_creation_time, last_login_time, account_status, version, account_type, display_name, about, sales_generated, tier
+	FROM base_users INNER JOIN marketiers ON base_users.user_id = marketiers.user_id WHERE base_users.user_id = $1`
 
 	var marketier MarketierUserAccount
 
@@ -296,9 +304,9 @@ func (marketierUserModel MarketierAccountModel) GetById(id int64) (*MarketierUse
 		&marketier.BaseUserAccount.FirstName,
 		&marketier.BaseUserAccount.LastName,
 		&marketier.BaseUserAccount.Email,
-		&marketier.BaseUserAccount.DateOfBirth,
-		&marketier.BaseUserAccount.Gender,
-		&marketier.BaseUserAccount.Address,
+		encryptedTime(marketierUserModel.Keyring, columnDateOfBirth, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.DateOfBirth),
+		encryptedString(marketierUserModel.Keyring, columnGender, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Gender),
+		encryptedString(marketierUserModel.Keyring, columnAddress, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Address),
 		&marketier.BaseUserAccount.Password.hash,
 		&marketier.BaseUserAccount.AccountCreationTime,
 		&marketier.BaseUserAccount.LastLoginTime,
@@ -322,3 +330,56 @@ func (marketierUserModel MarketierAccountModel) GetById(id int64) (*MarketierUse
 
 	return &marketier, nil
 }
+
+func (marketierUserModel MarketierAccountModel) GetAll() ([]*MarketierUserAccount, error) {
+	query := `
+	SELECT base_users.user_id, first_name, last_name, email, date_of_birth, gender, address, password, account_creation_time, last_login_time, account_status, version, account_type, display_name, about, sales_generated, tier
+	FROM base_users INNER JOIN marketiers ON base_users.user_id = marketiers.user_id
+	ORDER BY base_users.user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := marketierUserModel.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	marketiers := []*MarketierUserAccount{}
+
+	for rows.Next() {
+		var marketier MarketierUserAccount
+
+		err := rows.Scan(
+			&marketier.BaseUserAccount.UserId,
+			&marketier.BaseUserAccount.FirstName,
+			&marketier.BaseUserAccount.LastName,
+			&marketier.BaseUserAccount.Email,
+			encryptedTime(marketierUserModel.Keyring, columnDateOfBirth, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.DateOfBirth),
+			encryptedString(marketierUserModel.Keyring, columnGender, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Gender),
+			encryptedString(marketierUserModel.Keyring, columnAddress, &marketier.BaseUserAccount.UserId, &marketier.BaseUserAccount.Address),
+			&marketier.BaseUserAccount.Password.hash,
+			&marketier.BaseUserAccount.AccountCreationTime,
+			&marketier.BaseUserAccount.LastLoginTime,
+			&marketier.BaseUserAccount.AccountStatus,
+			&marketier.BaseUserAccount.Version,
+			&marketier.BaseUserAccount.AccountType,
+			&marketier.DisplayName,
+			&marketier.About,
+			&marketier.SalesGenerated,
+			&marketier.Tier,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		marketiers = append(marketiers, &marketier)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return marketiers, nil
+}
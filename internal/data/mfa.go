@@ -0,0 +1,304 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"marketier/internal/validator"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("mfa already enabled")
+)
+
+// RecoveryCodeCount is how many one-time recovery codes are issued when two-factor
+// authentication is turned on.
+const RecoveryCodeCount = 10
+
+type MFA struct {
+	UserID       int64      `json:"-"`
+	Secret       string     `json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// Enabled reports whether enrolment has been confirmed, so that logins need a second factor.
+func (m *MFA) Enabled() bool {
+	return m.ConfirmedAt != nil
+}
+
+func ValidateTOTPCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(code) == 6, "code", "must be 6 digits long")
+}
+
+// ValidateSecondFactor checks that exactly one of a TOTP code or a recovery code was given.
+func ValidateSecondFactor(v *validator.Validator, code, recoveryCode string) {
+	v.Check(code != "" || recoveryCode != "", "code", "must be provided")
+	v.Check(code == "" || recoveryCode == "", "recovery_code", "must not be provided together with code")
+
+	if code != "" {
+		ValidateTOTPCode(v, code)
+	}
+
+	v.Check(len(recoveryCode) <= 20, "recovery_code", "must not be more than 20 bytes long")
+}
+
+// generateRecoveryCode returns a random code written as two groups of five characters,
+// which is easier to copy down than one long string.
+func generateRecoveryCode() (string, error) {
+	randomBytes := make([]byte, 7)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so that the code can be typed the way
+// it was written down.
+func hashRecoveryCode(code string) []byte {
+	normalised := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	hash := sha256.Sum256([]byte(normalised))
+	return hash[:]
+}
+
+type MFAModel struct {
+	DB *sql.DB
+}
+
+func (m MFAModel) Get(userID int64) (*MFA, error) {
+	query := `
+        SELECT user_id, secret, confirmed_at, last_used_step, created_at
+        FROM user_mfa
+        WHERE user_id = $1`
+
+	var mfa MFA
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&mfa.UserID,
+		&mfa.Secret,
+		&mfa.ConfirmedAt,
+		&mfa.LastUsedStep,
+		&mfa.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &mfa, nil
+}
+
+// Enrol stores a new secret for the user. Starting again replaces an unconfirmed secret,
+// but a confirmed one has to be disabled first.
+func (m MFAModel) Enrol(userID int64, secret string) error {
+	query := `
+        INSERT INTO user_mfa (user_id, secret)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, last_used_step = 0, created_at = NOW()
+        WHERE user_mfa.confirmed_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+// Confirm turns two-factor authentication on once the user has entered a code from the
+// step given, and returns a fresh set of recovery codes. The codes are only stored hashed,
+// so this is the only time they can be shown.
+func (m MFAModel) Confirm(userID, step int64) ([]string, error) {
+	query := `
+        UPDATE user_mfa
+        SET confirmed_at = NOW(), last_used_step = $2
+        WHERE user_id = $1 AND confirmed_at IS NULL`
+
+	deleteQuery := `
+        DELETE FROM mfa_recovery_codes
+        WHERE user_id = $1`
+
+	insertQuery := `
+        INSERT INTO mfa_recovery_codes (user_id, hash)
+        VALUES ($1, $2)`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, deleteQuery, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, insertQuery, userID, hashRecoveryCode(codes[i]))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseStep records that a code from the given time step has been accepted. It returns
+// false if that step, or a later one, has been used already, which means the code is
+// being replayed.
+func (m MFAModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+        UPDATE user_mfa
+        SET last_used_step = $2
+        WHERE user_id = $1 AND last_used_step < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes. It returns false if the code
+// doesn't exist or has been used before.
+func (m MFAModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `
+        UPDATE mfa_recovery_codes
+        SET used_at = NOW()
+        WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// RemainingRecoveryCodes counts the recovery codes the user hasn't used yet.
+func (m MFAModel) RemainingRecoveryCodes(userID int64) (int, error) {
+	query := `
+        SELECT count(*)
+        FROM mfa_recovery_codes
+        WHERE user_id = $1 AND used_at IS NULL`
+
+	var remaining int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&remaining)
+
+	return remaining, err
+}
+
+// Disable turns two-factor authentication off, removing the secret and recovery codes.
+func (m MFAModel) Disable(userID int64) error {
+	query := `
+        DELETE FROM user_mfa
+        WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -3,6 +3,8 @@ package data
 import (
 	"database/sql"
 	"errors"
+
+	"marketier/internal/crypto"
 )
 
 var (
@@ -17,26 +19,54 @@ type Models struct {
 	Tokens             TokenModel
 	ProductModel       ProductModel
 	ProposalModel      ProposalModel
-	ContactModel       ContactModel
+	ThreadModel        ThreadModel
 	ReviewModel        ReviewModel
+	TrackingLinkModel  TrackingLinkModel
+	ConversionModel    ConversionModel
+	LedgerModel        LedgerModel
+	TierModel          TierModel
+	Permissions        PermissionModel
+	AdminModel         AdminModel
+	MFA                MFAModel
+	LoginAttempts      LoginAttemptModel
+	Identities         IdentityModel
+	APIKeys            APIKeyModel
+	EmailChanges       EmailChangeModel
+	DataExports        DataExportModel
+	AccountDeletions   AccountDeletionModel
+	SecurityEvents     SecurityEventModel
+	KnownDevices       KnownDeviceModel
 	/*Movies      MovieModel
-	Permissions PermissionModel
 
 	Users       UserModel*/
 }
 
-func NewModels(db *sql.DB) Models {
+func NewModels(db *sql.DB, keyring *crypto.Keyring) Models {
 	return Models{
-		BaseUsersModel:     BaseUserAccountModel{DB: db},
-		MarketierUserModel: MarketierAccountModel{DB: db},
-		ProductOwnerModel:  ProductOwnerAccountModel{DB: db},
+		BaseUsersModel:     BaseUserAccountModel{DB: db, Keyring: keyring},
+		MarketierUserModel: MarketierAccountModel{DB: db, Keyring: keyring},
+		ProductOwnerModel:  ProductOwnerAccountModel{DB: db, Keyring: keyring},
 		Tokens:             TokenModel{DB: db},
 		ProductModel:       ProductModel{DB: db},
 		ProposalModel:      ProposalModel{DB: db},
-		ContactModel:       ContactModel{DB: db},
+		ThreadModel:        ThreadModel{DB: db},
 		ReviewModel:        ReviewModel{DB: db},
+		TrackingLinkModel:  TrackingLinkModel{DB: db},
+		ConversionModel:    ConversionModel{DB: db},
+		LedgerModel:        LedgerModel{DB: db},
+		TierModel:          TierModel{DB: db},
+		Permissions:        PermissionModel{DB: db},
+		AdminModel:         AdminModel{DB: db, Keyring: keyring},
+		MFA:                MFAModel{DB: db},
+		LoginAttempts:      LoginAttemptModel{DB: db},
+		Identities:         IdentityModel{DB: db, Keyring: keyring},
+		APIKeys:            APIKeyModel{DB: db},
+		EmailChanges:       EmailChangeModel{DB: db},
+		DataExports:        DataExportModel{DB: db, Keyring: keyring},
+		AccountDeletions:   AccountDeletionModel{DB: db},
+		SecurityEvents:     SecurityEventModel{DB: db},
+		KnownDevices:       KnownDeviceModel{DB: db},
 		/*Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db},
 		Tokens:      TokenModel{DB: db},
 		Users:       UserModel{DB: db},*/
 	}
@@ -0,0 +1,28 @@
+package data
+
+import (
+	"regexp"
+
+	"marketier/internal/validator"
+)
+
+var currencyRX = regexp.MustCompile("^[A-Z]{3}$")
+
+// Money is an amount in the currency's minor unit (cents for USD, pence for GBP) so that
+// sums never pick up floating point rounding errors.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Percent returns the given share of the amount in basis points, rounded down to the
+// nearest minor unit.
+func (m Money) Percent(basisPoints int64) Money {
+	return Money{Amount: m.Amount * basisPoints / 10_000, Currency: m.Currency}
+}
+
+func ValidateMoney(v *validator.Validator, key string, money Money) {
+	v.Check(money.Amount > 0, key, "must be greater than zero")
+	v.Check(money.Amount <= 100_000_000_000, key, "must not be more than 1,000,000,000.00")
+	v.Check(validator.Matches(money.Currency, currencyRX), key, "currency must be a 3 letter ISO 4217 code")
+}
@@ -23,24 +23,58 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
+// GetAllForUser returns the permissions the user holds through the role for their account
+// type together with any granted to them directly.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `
         SELECT permissions.code
         FROM permissions
+        INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+        INNER JOIN roles ON roles.role_id = roles_permissions.role_id
+        INNER JOIN base_users ON base_users.account_type = roles.account_type
+        WHERE base_users.user_id = $1
+        UNION
+        SELECT permissions.code
+        FROM permissions
         INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-        INNER JOIN users ON users_permissions.user_id = users.id
-        WHERE users.id = $1`
+        WHERE users_permissions.user_id = $1`
+
+	return m.queryCodes(query, userID)
+}
+
+// GetGrantsForUser returns only the permissions granted to the user directly.
+func (m PermissionModel) GetGrantsForUser(userID int64) (Permissions, error) {
+	query := `
+        SELECT permissions.code
+        FROM permissions
+        INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+        WHERE users_permissions.user_id = $1
+        ORDER BY permissions.code`
+
+	return m.queryCodes(query, userID)
+}
+
+// GetAll returns every permission code known to the system.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `
+        SELECT code
+        FROM permissions
+        ORDER BY code`
+
+	return m.queryCodes(query)
+}
 
+func (m PermissionModel) queryCodes(query string, args ...interface{}) (Permissions, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var permissions Permissions
+	permissions := Permissions{}
 
 	for rows.Next() {
 		var permission string
@@ -61,8 +95,9 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	query := `
-        INSERT INTO users_permissions
-        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
+        INSERT INTO users_permissions (user_id, permission_id)
+        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+        ON CONFLICT DO NOTHING`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -70,3 +105,28 @@ func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
 	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
 	return err
 }
+
+// addPermissionsForUser grants the codes to the user using the caller's transaction.
+func addPermissionsForUser(ctx context.Context, tx *sql.Tx, userID int64, codes ...string) error {
+	query := `
+        INSERT INTO users_permissions (user_id, permission_id)
+        SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+        ON CONFLICT DO NOTHING`
+
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// removePermissionsForUser takes back codes granted to the user directly, using the
+// caller's transaction. Permissions held through the user's role are unaffected.
+func removePermissionsForUser(ctx context.Context, tx *sql.Tx, userID int64, codes ...string) error {
+	query := `
+        DELETE FROM users_permissions
+        USING permissions
+        WHERE users_permissions.permission_id = permissions.id
+        AND users_permissions.user_id = $1
+        AND permissions.code = ANY($2)`
+
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
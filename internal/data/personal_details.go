@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strconv"
+	"time"
+
+	"marketier/internal/crypto"
+)
+
+// The base_users columns holding personal details, which are encrypted at rest. Each
+// value is encrypted with its column name and user id as associated data, so a value
+// can't be moved to another column or another user's row and still decrypt.
+const (
+	columnDateOfBirth = "date_of_birth"
+	columnGender      = "gender"
+	columnAddress     = "address"
+)
+
+func associatedData(column string, userID int64) []byte {
+	return []byte(column + ":" + strconv.FormatInt(userID, 10))
+}
+
+// encryptedStringColumn encrypts a string when it's passed as a query argument and
+// decrypts it when it's scanned. Values stored before encryption was turned on are read
+// as they are, until the re-encryption job gets to them, unless the keyring requires
+// every value to be encrypted.
+//
+// The user id is only read when the value is, so when scanning a row the user_id column
+// has to come before the encrypted ones.
+type encryptedStringColumn struct {
+	keyring *crypto.Keyring
+	column  string
+	userID  *int64
+	value   *string
+}
+
+func encryptedString(keyring *crypto.Keyring, column string, userID *int64, value *string) encryptedStringColumn {
+	return encryptedStringColumn{keyring: keyring, column: column, userID: userID, value: value}
+}
+
+func (c encryptedStringColumn) Value() (driver.Value, error) {
+	return c.keyring.Encrypt([]byte(*c.value), associatedData(c.column, *c.userID))
+}
+
+func (c encryptedStringColumn) Scan(src interface{}) error {
+	var stored string
+
+	switch src := src.(type) {
+	case string:
+		stored = src
+	case []byte:
+		stored = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into an encrypted %s", src, c.column)
+	}
+
+	if !crypto.IsEncrypted(stored) {
+		err := c.keyring.CheckPlaintext(stored)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.column, err)
+		}
+
+		*c.value = stored
+		return nil
+	}
+
+	plaintext, err := c.keyring.Decrypt(stored, associatedData(c.column, *c.userID))
+	if err != nil {
+		return err
+	}
+
+	*c.value = string(plaintext)
+	return nil
+}
+
+// encryptedTimeColumn is encryptedStringColumn for a time, which is encrypted in RFC 3339
+// format.
+type encryptedTimeColumn struct {
+	keyring *crypto.Keyring
+	column  string
+	userID  *int64
+	value   *time.Time
+}
+
+func encryptedTime(keyring *crypto.Keyring, column string, userID *int64, value *time.Time) encryptedTimeColumn {
+	return encryptedTimeColumn{keyring: keyring, column: column, userID: userID, value: value}
+}
+
+func (c encryptedTimeColumn) Value() (driver.Value, error) {
+	formatted := c.value.Format(time.RFC3339)
+	return encryptedString(c.keyring, c.column, c.userID, &formatted).Value()
+}
+
+func (c encryptedTimeColumn) Scan(src interface{}) error {
+	var formatted string
+
+	err := encryptedString(c.keyring, c.column, c.userID, &formatted).Scan(src)
+	if err != nil {
+		return err
+	}
+
+	*c.value, err = time.Parse(time.RFC3339, formatted)
+	return err
+}
+
+// writePersonalDetails encrypts the user's personal details into their row. The details
+// are bound to the user id, so a new user's row has to be inserted first, and this called
+// in the same transaction once the id is known.
+func writePersonalDetails(ctx context.Context, tx *sql.Tx, keyring *crypto.Keyring, user *BaseUserAccount) error {
+	query := `
+        UPDATE base_users
+        SET date_of_birth = $1, gender = $2, address = $3
+        WHERE user_id = $4`
+
+	args := []interface{}{
+		encryptedTime(keyring, columnDateOfBirth, &user.UserId, &user.DateOfBirth),
+		encryptedString(keyring, columnGender, &user.UserId, &user.Gender),
+		encryptedString(keyring, columnAddress, &user.UserId, &user.Address),
+		user.UserId,
+	}
+
+	_, err := tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// reencrypt brings a stored value up to date with the current key: plaintext from before
+// encryption was turned on is encrypted, and a value under an old key has its data key
+// sealed again. It reports whether the value changed. Plaintext is refused rather than
+// encrypted when the keyring requires every value to be encrypted already.
+func reencrypt(keyring *crypto.Keyring, column string, userID int64, stored string) (string, bool, error) {
+	if !crypto.IsEncrypted(stored) {
+		err := keyring.CheckPlaintext(stored)
+		if err != nil {
+			return "", false, err
+		}
+
+		updated, err := encryptedString(keyring, column, &userID, &stored).Value()
+		if err != nil {
+			return "", false, err
+		}
+
+		return updated.(string), true, nil
+	}
+
+	updated, err := keyring.Rewrap(stored)
+	if err != nil {
+		return "", false, err
+	}
+
+	return updated, updated != stored, nil
+}
+
+// ReencryptPersonalDetails brings the personal details of up to limit users after afterID
+// up to date with the current key, for when the key has been rotated or encryption has
+// just been turned on. It returns the last user id it looked at, to carry on from in the
+// next batch, or 0 once there are no users left, along with how many users it updated.
+// The version isn't bumped, since the details themselves don't change.
+func (m BaseUserAccountModel) ReencryptPersonalDetails(afterID int64, limit int) (int64, int, error) {
+	query := `
+        SELECT user_id, date_of_birth, gender, address
+        FROM base_users
+        WHERE user_id > $1
+        ORDER BY user_id
+        LIMIT $2
+        FOR UPDATE`
+
+	updateQuery := `
+        UPDATE base_users
+        SET date_of_birth = $1, gender = $2, address = $3
+        WHERE user_id = $4`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	type storedDetails struct {
+		userID int64
+		values [3]string
+	}
+
+	rows, err := tx.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	batch := []storedDetails{}
+
+	for rows.Next() {
+		var details storedDetails
+
+		err := rows.Scan(&details.userID, &details.values[0], &details.values[1], &details.values[2])
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return 0, 0, err
+		}
+
+		batch = append(batch, details)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+
+	if len(batch) == 0 {
+		tx.Rollback()
+		return 0, 0, nil
+	}
+
+	columns := [3]string{columnDateOfBirth, columnGender, columnAddress}
+	updated := 0
+
+	for _, details := range batch {
+		changed := false
+
+		for i, column := range columns {
+			value, valueChanged, err := reencrypt(m.Keyring, column, details.userID, details.values[i])
+			if err != nil {
+				tx.Rollback()
+				return 0, 0, fmt.Errorf("user %d %s: %w", details.userID, column, err)
+			}
+
+			details.values[i] = value
+			changed = changed || valueChanged
+		}
+
+		if !changed {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, updateQuery, details.values[0], details.values[1], details.values[2], details.userID)
+		if err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+
+		updated++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return batch[len(batch)-1].userID, updated, nil
+}
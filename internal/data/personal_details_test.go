@@ -0,0 +1,87 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"marketier/internal/crypto"
+)
+
+func newTestKeyring(t *testing.T) *crypto.Keyring {
+	t.Helper()
+
+	keyring, err := crypto.NewKeyring("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, crypto.KeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyring
+}
+
+func TestEncryptedStringBindsUser(t *testing.T) {
+	keyring := newTestKeyring(t)
+
+	userID := int64(7)
+	address := "1 Example Street"
+
+	stored, err := encryptedString(keyring, columnAddress, &userID, &address).Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		column  string
+		userID  int64
+		wantErr bool
+	}{
+		{"same user and column", columnAddress, 7, false},
+		{"another user", columnAddress, 8, true},
+		{"another column", columnGender, 7, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+
+			err := encryptedString(keyring, tt.column, &tt.userID, &got).Scan(stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan error = %v, want error %t", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got != address {
+				t.Errorf("Scan = %q, want %q", got, address)
+			}
+		})
+	}
+}
+
+func TestEncryptedStringPlaintext(t *testing.T) {
+	keyring := newTestKeyring(t)
+	userID := int64(7)
+
+	var got string
+
+	err := encryptedString(keyring, columnAddress, &userID, &got).Scan([]byte("1 Example Street"))
+	if err != nil || got != "1 Example Street" {
+		t.Fatalf("Scan of plaintext = %q, %v", got, err)
+	}
+
+	_, changed, err := reencrypt(keyring, columnAddress, userID, "1 Example Street")
+	if err != nil || !changed {
+		t.Fatalf("reencrypt of plaintext = %t, %v, want it encrypted", changed, err)
+	}
+
+	keyring.RequireEncrypted()
+
+	err = encryptedString(keyring, columnAddress, &userID, &got).Scan([]byte("1 Example Street"))
+	if !errors.Is(err, crypto.ErrNotEncrypted) {
+		t.Errorf("Scan of plaintext once encryption is required = %v, want ErrNotEncrypted", err)
+	}
+
+	_, _, err = reencrypt(keyring, columnAddress, userID, "1 Example Street")
+	if !errors.Is(err, crypto.ErrNotEncrypted) {
+		t.Errorf("reencrypt of plaintext once encryption is required = %v, want ErrNotEncrypted", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"marketier/internal/crypto"
 	"marketier/internal/validator"
 	"time"
 )
@@ -17,16 +18,17 @@ type ProductOwnerUserAccount struct {
 }
 
 type ProductOwnerAccountModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Keyring *crypto.Keyring
 }
 
 func (productOwnerModel ProductOwnerAccountModel) Insert(productOwnerUser *ProductOwnerUserAccount) error {
 	baseQuery := `
         INSERT INTO base_users (first_name, last_name, email, date_of_birth, gender, address, password, account_type) 
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        VALUES ($1, $2, $3, '', '', '', $4, $5)
         RETURNING user_id, account_creation_time, account_status, version`
 
-	baseArgs := []interface{}{productOwnerUser.BaseUserAccount.FirstName, productOwnerUser.BaseUserAccount.LastName, productOwnerUser.BaseUserAccount.Email, productOwnerUser.BaseUserAccount.DateOfBirth, productOwnerUser.BaseUserAccount.Gender, productOwnerUser.BaseUserAccount.Address, productOwnerUser.BaseUserAccount.Password.hash, productOwnerUser.BaseUserAccount.AccountType}
+	baseArgs := []interface{}{productOwnerUser.BaseUserAccount.FirstName, productOwnerUser.BaseUserAccount.LastName, productOwnerUser.BaseUserAccount.Email, productOwnerUser.BaseUserAccount.Password.hash, productOwnerUser.BaseUserAccount.AccountType}
 
 	marketierQuery := `
 	INSERT INTO product_owners (user_id, display_name, about, sales_generated) 
@@ -55,6 +57,12 @@ func (productOwnerModel ProductOwnerAccountModel) Insert(productOwnerUser *Produ
 		}
 	}
 
+	err = writePersonalDetails(ctx, tx, productOwnerModel.Keyring, &productOwnerUser.BaseUserAccount)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	updatedMarketierArgs := append([]interface{}{productOwnerUser.BaseUserAccount.UserId}, marketierArgs...)
 
 	// Execute the second insertion
@@ -88,9 +96,9 @@ func (productOwnerModel ProductOwnerAccountModel) GetByEmail(email string) (*Pro
 		&productOwner.BaseUserAccount.FirstName,
 		&productOwner.BaseUserAccount.LastName,
 		&productOwner.BaseUserAccount.Email,
-		&productOwner.BaseUserAccount.DateOfBirth,
-		&productOwner.BaseUserAccount.Gender,
-		&productOwner.BaseUserAccount.Address,
+		encryptedTime(productOwnerModel.Keyring, columnDateOfBirth, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.DateOfBirth),
+		encryptedString(productOwnerModel.Keyring, columnGender, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.Gender),
+		encryptedString(productOwnerModel.Keyring, columnAddress, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.Address),
 		&productOwner.BaseUserAccount.Password.hash,
 		&productOwner.BaseUserAccount.AccountCreationTime,
 		&productOwner.BaseUserAccount.LastLoginTime,
@@ -148,31 +156,30 @@ type MarketierUserAccount struct {
 func (productOwnerModel ProductOwnerAccountModel) Update(productOwnerUser *ProductOwnerUserAccount) error {
 	baseQuery := `
         UPDATE base_users
-        SET first_name = $1, last_name = $2, email = $3, address = $4, password = $5, last_login_time = $6, account_status = $7, version = version + 1
-        WHERE user_id = $8 AND version = $9
+        SET first_name = $1, last_name = $2, address = $3, password = $4, last_login_time = $5, version = version + 1
+        WHERE user_id = $6 AND version = $7
         RETURNING version`
 
 	baseArgs := []interface{}{
 		productOwnerUser.BaseUserAccount.FirstName,
 		productOwnerUser.BaseUserAccount.LastName,
-		productOwnerUser.BaseUserAccount.Email,
-		productOwnerUser.BaseUserAccount.Address,
+		encryptedString(productOwnerModel.Keyring, columnAddress, &productOwnerUser.BaseUserAccount.UserId, &productOwnerUser.BaseUserAccount.Address),
 		productOwnerUser.BaseUserAccount.Password.hash,
 		productOwnerUser.BaseUserAccount.LastLoginTime,
-		productOwnerUser.BaseUserAccount.AccountStatus,
 		productOwnerUser.BaseUserAccount.UserId,
 		productOwnerUser.BaseUserAccount.Version,
 	}
 
+	// Sales generated is kept up to date by conversions, so it isn't written back from
+	// what may be a stale read.
 	marketierQuery := `
 		UPDATE product_owners
-		SET display_name = $1, about = $2, sales_generated = $3
-		WHERE user_id = $4`
+		SET display_name = $1, about = $2
+		WHERE user_id = $3`
 
 	marketierArgs := []interface{}{
 		productOwnerUser.DisplayName,
 		productOwnerUser.About,
-		productOwnerUser.SalesGenerated,
 		productOwnerUser.BaseUserAccount.UserId,
 	}
 
@@ -192,6 +199,8 @@ func (productOwnerModel ProductOwnerAccountModel) Update(productOwnerUser *Produ
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "base_users_email_key"`:
 			return ErrDuplicateEmail
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -236,9 +245,9 @@ func (productOwnerModel ProductOwnerAccountModel) GetForToken(tokenScope, tokenP
 		&productOwner.BaseUserAccount.FirstName,
 		&productOwner.BaseUserAccount.LastName,
 		&productOwner.BaseUserAccount.Email,
-		&productOwner.BaseUserAccount.DateOfBirth,
-		&productOwner.BaseUserAccount.Gender,
-		&productOwner.BaseUserAccount.Address,
+		encryptedTime(productOwnerModel.Keyring, columnDateOfBirth, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.DateOfBirth),
+		encryptedString(productOwnerModel.Keyring, columnGender, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.Gender),
+		encryptedString(productOwnerModel.Keyring, columnAddress, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.Address),
 		&productOwner.BaseUserAccount.Password.hash,
 		&productOwner.BaseUserAccount.AccountCreationTime,
 		&productOwner.BaseUserAccount.LastLoginTime,
@@ -277,8 +286,8 @@ func (productOwnerUserModel ProductOwnerAccountModel) GetById(id int64) (*Produc
 	}
 
 	query := `
-	SELECT base_user.user_id, first_name, last_name, email, date_of_birth, gender, address, password, account_creation_time, last_login_time, account_status, version, account_type, display_name, about, sales_generated, tier
-	FROM base_users INNER JOIN product_owners ON base_users.user_id = product_owners.user_id WHERE users.user_id = $1`
+	SELECT base_users.user_id, first_name, last_name, email, date_of_birth, gender, address, password, account_creation_time, last_login_time, account_status, version, account_type, display_name, about, sales_generated
+	FROM base_users INNER JOIN product_owners ON base_users.user_id = product_owners.user_id WHERE base_users.user_id = $1`
 
 	var productOwner ProductOwnerUserAccount
 
@@ -290,9 +299,9 @@ func (productOwnerUserModel ProductOwnerAccountModel) GetById(id int64) (*Produc
 		&productOwner.BaseUserAccount.FirstName,
 		&productOwner.BaseUserAccount.LastName,
 		&productOwner.BaseUserAccount.Email,
-		&productOwner.BaseUserAccount.DateOfBirth,
-		&productOwner.BaseUserAccount.Gender,
-		&productOwner.BaseUserAccount.Address,
+		encryptedTime(productOwnerUserModel.Keyring, columnDateOfBirth, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.DateOfBirth),
+		encryptedString(productOwnerUserModel.Keyring, columnGender, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.Gender),
+		encryptedString(productOwnerUserModel.Keyring, columnAddress, &productOwner.BaseUserAccount.UserId, &productOwner.BaseUserAccount.Address),
 		&productOwner.BaseUserAccount.Password.hash,
 		&productOwner.BaseUserAccount.AccountCreationTime,
 		&productOwner.BaseUserAccount.LastLoginTime,
@@ -4,16 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"marketier/internal/validator"
 	"time"
 )
 
+// Product ratings are kept as the exact average of the approved reviews, and as that
+// average rounded to whole stars for filtering on. Products from before owners were
+// tracked have an OwnerId of 0 until one is assigned.
 type Product struct {
-	ProductId int64  `json:"product_id"`
-	Name      string `json:"name"`
-	About     string `json:"about"`
-	Stars     int8   `json:"stars"`
-	Version   int    `json:"version"`
+	ProductId     int64   `json:"product_id"`
+	OwnerId       int64   `json:"owner_id"`
+	Name          string  `json:"name"`
+	About         string  `json:"about"`
+	Stars         int8    `json:"stars"`
+	AverageRating float64 `json:"average_rating"`
+	ReviewCount   int     `json:"review_count"`
+	Version       int     `json:"version"`
 }
 
 type ProductModel struct {
@@ -29,16 +36,16 @@ func ValidateProduct(v *validator.Validator, product *Product) {
 
 func (p ProductModel) Insert(product *Product) error {
 	query := `
-        INSERT INTO products (name, about) 
-        VALUES ($1, $2)
-        RETURNING product_id, stars, version`
+        INSERT INTO products (owner_id, name, about) 
+        VALUES ($1, $2, $3)
+        RETURNING product_id, stars, average_rating, review_count, version`
 
-	args := []interface{}{product.Name, product.About}
+	args := []interface{}{product.OwnerId, product.Name, product.About}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.ProductId, &product.Stars, &product.Version)
+	return p.DB.QueryRowContext(ctx, query, args...).Scan(&product.ProductId, &product.Stars, &product.AverageRating, &product.ReviewCount, &product.Version)
 }
 
 func (p ProductModel) Get(id int64) (*Product, error) {
@@ -47,9 +54,9 @@ func (p ProductModel) Get(id int64) (*Product, error) {
 	}
 
 	query := `
-        SELECT product_id, name, about, stars, version
+        SELECT product_id, COALESCE(owner_id, 0) AS owner_id, name, about, stars, average_rating, review_count, version
         FROM products
-        WHERE id = $1`
+        WHERE product_id = $1`
 
 	var product Product
 
@@ -58,9 +65,12 @@ func (p ProductModel) Get(id int64) (*Product, error) {
 
 	err := p.DB.QueryRowContext(ctx, query, id).Scan(
 		&product.ProductId,
+		&product.OwnerId,
 		&product.Name,
 		&product.About,
 		&product.Stars,
+		&product.AverageRating,
+		&product.ReviewCount,
 		&product.Version,
 	)
 
@@ -83,7 +93,7 @@ func (p ProductModel) Delete(id int64) error {
 
 	query := `
         DELETE FROM products
-        WHERE id = $1`
+        WHERE product_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -108,14 +118,13 @@ func (p ProductModel) Delete(id int64) error {
 func (p ProductModel) Update(product *Product) error {
 	query := `
         UPDATE products 
-        SET name = $1, about = $2, stars = $3, version = version + 1
-        WHERE id = $4 AND version = $5
+        SET name = $1, about = $2, version = version + 1
+        WHERE product_id = $3 AND version = $4
         RETURNING version`
 
 	args := []interface{}{
 		product.Name,
 		product.About,
-		product.Stars,
 		product.ProductId,
 		product.Version,
 	}
@@ -135,3 +144,59 @@ func (p ProductModel) Update(product *Product) error {
 
 	return nil
 }
+
+// An ownerId of 0 lists products belonging to every product owner.
+func (p ProductModel) GetAll(ownerId int64, search string, minStars int, maxStars int, filters Filters) ([]*Product, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), product_id, COALESCE(owner_id, 0) AS owner_id, name, about, stars, average_rating, review_count, version
+        FROM products
+        WHERE (owner_id = $1 OR $1 = 0)
+        AND (to_tsvector('simple', name || ' ' || about) @@ plainto_tsquery('simple', $2) OR $2 = '')
+        AND stars BETWEEN $3 AND $4
+        ORDER BY %s %s, product_id ASC
+        LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{ownerId, search, minStars, maxStars, filters.limit(), filters.offset()}
+
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	products := []*Product{}
+
+	for rows.Next() {
+		var product Product
+
+		err := rows.Scan(
+			&totalRecords,
+			&product.ProductId,
+			&product.OwnerId,
+			&product.Name,
+			&product.About,
+			&product.Stars,
+			&product.AverageRating,
+			&product.ReviewCount,
+			&product.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		products = append(products, &product)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return products, metadata, nil
+}
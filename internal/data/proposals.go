@@ -8,11 +8,49 @@ import (
 	"time"
 )
 
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+const (
+	ProposalStatusDraft     = "draft"
+	ProposalStatusSubmitted = "submitted"
+	ProposalStatusAccepted  = "accepted"
+	ProposalStatusRejected  = "rejected"
+	ProposalStatusWithdrawn = "withdrawn"
+	ProposalStatusCompleted = "completed"
+)
+
+// proposalTransitions maps each proposal status to the statuses it may move to next.
+var proposalTransitions = map[string][]string{
+	ProposalStatusDraft:     {ProposalStatusSubmitted, ProposalStatusWithdrawn},
+	ProposalStatusSubmitted: {ProposalStatusAccepted, ProposalStatusRejected, ProposalStatusWithdrawn},
+	ProposalStatusAccepted:  {ProposalStatusCompleted},
+}
+
+func CanTransitionProposal(from, to string) bool {
+	return validator.In(to, proposalTransitions[from]...)
+}
+
 type Proposal struct {
-	ProposalId int64  `json:"proposal_id"`
-	Title      string `json:"title"`
-	About      string `json:"about"`
-	Version    int    `json:"version"`
+	ProposalId  int64     `json:"proposal_id"`
+	MarketierId int64     `json:"marketier_id"`
+	ProductId   int64     `json:"product_id"`
+	Title       string    `json:"title"`
+	About       string    `json:"about"`
+	Status      string    `json:"status"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int       `json:"version"`
+}
+
+type ProposalEvent struct {
+	EventId    int64     `json:"event_id"`
+	ProposalId int64     `json:"proposal_id"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status"`
+	ActorId    int64     `json:"actor_id"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type ProposalModel struct {
@@ -20,6 +58,8 @@ type ProposalModel struct {
 }
 
 func ValidateProposal(v *validator.Validator, proposal *Proposal) {
+	v.Check(proposal.MarketierId > 0, "marketier_id", "must be provided")
+	v.Check(proposal.ProductId > 0, "product_id", "must be provided")
 	v.Check(proposal.Title != "", "title", "must be provided")
 	v.Check(len(proposal.Title) <= 250, "title", "must not be more than 250 bytes long")
 	v.Check(proposal.About != "", "about", "must be provided")
@@ -28,16 +68,38 @@ func ValidateProposal(v *validator.Validator, proposal *Proposal) {
 
 func (p ProposalModel) Insert(proposal *Proposal) error {
 	query := `
-        INSERT INTO proposals (title, about) 
-        VALUES ($1, $2)
-        RETURNING product_id, version`
+        INSERT INTO proposals (marketier_id, product_id, title, about)
+        VALUES ($1, $2, $3, $4)
+        RETURNING proposal_id, status, created_at, updated_at, version`
 
-	args := []interface{}{proposal.Title, proposal.About}
+	args := []interface{}{proposal.MarketierId, proposal.ProductId, proposal.Title, proposal.About}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	historyQuery := `
+        INSERT INTO proposal_history (proposal_id, from_status, to_status, actor_id)
+        VALUES ($1, '', $2, $3)`
+
+	// Begin a transaction
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
-	return p.DB.QueryRowContext(ctx, query, args...).Scan(&proposal.ProposalId, &proposal.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&proposal.ProposalId, &proposal.Status, &proposal.CreatedAt, &proposal.UpdatedAt, &proposal.Version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, historyQuery, proposal.ProposalId, proposal.Status, proposal.MarketierId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (p ProposalModel) Get(id int64) (*Proposal, error) {
@@ -46,9 +108,9 @@ func (p ProposalModel) Get(id int64) (*Proposal, error) {
 	}
 
 	query := `
-        SELECT proposal_id, title, about, version
-        FROM products
-        WHERE id = $1`
+        SELECT proposal_id, marketier_id, product_id, title, about, status, created_at, updated_at, version
+        FROM proposals
+        WHERE proposal_id = $1`
 
 	var proposal Proposal
 
@@ -57,8 +119,13 @@ func (p ProposalModel) Get(id int64) (*Proposal, error) {
 
 	err := p.DB.QueryRowContext(ctx, query, id).Scan(
 		&proposal.ProposalId,
+		&proposal.MarketierId,
+		&proposal.ProductId,
 		&proposal.Title,
 		&proposal.About,
+		&proposal.Status,
+		&proposal.CreatedAt,
+		&proposal.UpdatedAt,
 		&proposal.Version,
 	)
 
@@ -81,7 +148,7 @@ func (p ProposalModel) Delete(id int64) error {
 
 	query := `
         DELETE FROM proposals
-        WHERE id = $1`
+        WHERE proposal_id = $1`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -105,10 +172,10 @@ func (p ProposalModel) Delete(id int64) error {
 
 func (p ProposalModel) Update(proposal *Proposal) error {
 	query := `
-        UPDATE proposals 
-        SET title = $1, about = $2, version = version + 1
-        WHERE id = $4 AND version = $5
-        RETURNING version`
+        UPDATE proposals
+        SET title = $1, about = $2, updated_at = NOW(), version = version + 1
+        WHERE proposal_id = $3 AND version = $4
+        RETURNING updated_at, version`
 
 	args := []interface{}{
 		proposal.Title,
@@ -120,7 +187,7 @@ func (p ProposalModel) Update(proposal *Proposal) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := p.DB.QueryRowContext(ctx, query, args...).Scan(&proposal.Version)
+	err := p.DB.QueryRowContext(ctx, query, args...).Scan(&proposal.UpdatedAt, &proposal.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -132,3 +199,139 @@ func (p ProposalModel) Update(proposal *Proposal) error {
 
 	return nil
 }
+
+// Transition moves the proposal to the given status and records the change in the
+// proposal's history. Both writes happen in a single transaction.
+func (p ProposalModel) Transition(proposal *Proposal, toStatus string, actorId int64) error {
+	// Begin a transaction
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = transitionProposal(ctx, tx, proposal, toStatus, actorId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	proposal.Status = toStatus
+
+	return nil
+}
+
+// Accept moves the proposal to accepted and returns the marketier's tracking link for the
+// product, creating it if need be, in the same transaction.
+func (p ProposalModel) Accept(proposal *Proposal, actorId int64) (*TrackingLink, error) {
+	// Begin a transaction
+	tx, err := p.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = transitionProposal(ctx, tx, proposal, ProposalStatusAccepted, actorId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	link, err := insertTrackingLink(ctx, tx, proposal.MarketierId, proposal.ProductId, proposal.ProposalId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	proposal.Status = ProposalStatusAccepted
+
+	return link, nil
+}
+
+// transitionProposal makes the status change for Transition and Accept inside the caller's
+// transaction. The caller sets the new status on the proposal once the transaction commits.
+func transitionProposal(ctx context.Context, tx *sql.Tx, proposal *Proposal, toStatus string, actorId int64) error {
+	if !CanTransitionProposal(proposal.Status, toStatus) {
+		return ErrInvalidTransition
+	}
+
+	query := `
+        UPDATE proposals
+        SET status = $1, updated_at = NOW(), version = version + 1
+        WHERE proposal_id = $2 AND version = $3
+        RETURNING updated_at, version`
+
+	historyQuery := `
+        INSERT INTO proposal_history (proposal_id, from_status, to_status, actor_id)
+        VALUES ($1, $2, $3, $4)`
+
+	err := tx.QueryRowContext(ctx, query, toStatus, proposal.ProposalId, proposal.Version).Scan(&proposal.UpdatedAt, &proposal.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, historyQuery, proposal.ProposalId, proposal.Status, toStatus, actorId)
+	return err
+}
+
+func (p ProposalModel) GetHistory(proposalId int64) ([]*ProposalEvent, error) {
+	query := `
+        SELECT event_id, proposal_id, from_status, to_status, COALESCE(actor_id, 0), created_at
+        FROM proposal_history
+        WHERE proposal_id = $1
+        ORDER BY created_at ASC, event_id ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, proposalId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*ProposalEvent{}
+
+	for rows.Next() {
+		var event ProposalEvent
+
+		err := rows.Scan(
+			&event.EventId,
+			&event.ProposalId,
+			&event.FromStatus,
+			&event.ToStatus,
+			&event.ActorId,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
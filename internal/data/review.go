@@ -4,16 +4,39 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"marketier/internal/validator"
 	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Reviews start out pending and only count towards a product's rating once approved,
+// either automatically by the moderation rules or by an admin.
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
 )
 
 type Review struct {
-	ReviewId int64  `json:"review_id"`
-	UserId   int64  `json:"user_id"`
-	Title    string `json:"title"`
-	About    string `json:"about"`
-	Version  int    `json:"version"`
+	ReviewId        int64      `json:"review_id"`
+	UserId          int64      `json:"user_id"`
+	ProductId       int64      `json:"product_id"`
+	Rating          int8       `json:"rating"`
+	Title           string     `json:"title"`
+	About           string     `json:"about"`
+	Status          string     `json:"status"`
+	Flags           []string   `json:"flags,omitempty"`
+	RejectionReason string     `json:"rejection_reason,omitempty"`
+	ModeratedBy     *int64     `json:"moderated_by,omitempty"`
+	ModeratedAt     *time.Time `json:"moderated_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Version         int        `json:"version"`
 }
 
 type ReviewModel struct {
@@ -21,24 +44,81 @@ type ReviewModel struct {
 }
 
 func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.ProductId > 0, "product_id", "must be provided")
+	v.Check(review.Rating >= 1 && review.Rating <= 5, "rating", "must be between 1 and 5")
 	v.Check(review.Title != "", "title", "must be provided")
 	v.Check(len(review.Title) <= 250, "title", "must not be more than 250 bytes long")
 	v.Check(review.About != "", "about", "must be provided")
-	v.Check(len(review.About) <= 1000, "name", "must not be more than 1000 bytes long")
+	v.Check(len(review.About) <= 1000, "about", "must not be more than 1000 bytes long")
+}
+
+func ValidateRejectionReason(v *validator.Validator, reason string) {
+	v.Check(reason != "", "reason", "must be provided")
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// refreshProductRating recalculates the product's star rating and review count from its
+// approved reviews, using the caller's transaction so the aggregate never drifts from the rows.
+func refreshProductRating(ctx context.Context, tx *sql.Tx, productId int64) error {
+	// Lock the product first so that the aggregate below is read after any concurrent
+	// review change for the same product has committed.
+	lockQuery := `
+        SELECT product_id
+        FROM products
+        WHERE product_id = $1
+        FOR UPDATE`
+
+	_, err := tx.ExecContext(ctx, lockQuery, productId)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE products
+        SET stars = COALESCE((SELECT ROUND(AVG(rating)) FROM reviews WHERE product_id = $1 AND status = 'approved'), 0),
+            average_rating = COALESCE((SELECT AVG(rating) FROM reviews WHERE product_id = $1 AND status = 'approved'), 0),
+            review_count = (SELECT count(*) FROM reviews WHERE product_id = $1 AND status = 'approved')
+        WHERE product_id = $1`
+
+	_, err = tx.ExecContext(ctx, query, productId)
+	return err
 }
 
 func (r ReviewModel) Insert(review *Review) error {
 	query := `
-        INSERT INTO reviews (user_id, title, about) 
-        VALUES ($1, $2, $3)
-        RETURNING review_id, version`
+        INSERT INTO reviews (user_id, product_id, rating, title, about, status, flags)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING review_id, created_at, version`
 
-	args := []interface{}{review.UserId, review.Title, review.About}
+	args := []interface{}{review.UserId, review.ProductId, review.Rating, review.Title, review.About, review.Status, pq.Array(review.Flags)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Begin a transaction
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
-	return r.DB.QueryRowContext(ctx, query, args...).Scan(&review.ReviewId, &review.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ReviewId, &review.CreatedAt, &review.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_user_id_product_id_key"`:
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	err = refreshProductRating(ctx, tx, review.ProductId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (r ReviewModel) Get(id int64) (*Review, error) {
@@ -47,9 +127,9 @@ func (r ReviewModel) Get(id int64) (*Review, error) {
 	}
 
 	query := `
-        SELECT review_id, user_id, title, about, version
+        SELECT review_id, COALESCE(user_id, 0), product_id, rating, title, about, status, flags, rejection_reason, moderated_by, moderated_at, created_at, version
         FROM reviews
-        WHERE id = $1`
+        WHERE review_id = $1`
 
 	var review Review
 
@@ -59,8 +139,16 @@ func (r ReviewModel) Get(id int64) (*Review, error) {
 	err := r.DB.QueryRowContext(ctx, query, id).Scan(
 		&review.ReviewId,
 		&review.UserId,
+		&review.ProductId,
+		&review.Rating,
 		&review.Title,
 		&review.About,
+		&review.Status,
+		pq.Array(&review.Flags),
+		&review.RejectionReason,
+		&review.ModeratedBy,
+		&review.ModeratedAt,
+		&review.CreatedAt,
 		&review.Version,
 	)
 
@@ -76,54 +164,76 @@ func (r ReviewModel) Get(id int64) (*Review, error) {
 	return &review, nil
 }
 
-func (r ReviewModel) Delete(id int64) error {
-	if id < 1 {
-		return ErrRecordNotFound
-	}
-
+func (r ReviewModel) Delete(review *Review) error {
 	query := `
         DELETE FROM reviews
-        WHERE id = $1`
+        WHERE review_id = $1`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Begin a transaction
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
-	result, err := r.DB.ExecContext(ctx, query, id)
+	result, err := tx.ExecContext(ctx, query, review.ReviewId)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
 
 	if rowsAffected == 0 {
+		tx.Rollback()
 		return ErrRecordNotFound
 	}
 
-	return nil
+	err = refreshProductRating(ctx, tx, review.ProductId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (r ReviewModel) Update(review *Review) error {
 	query := `
-        UPDATE reviews 
-        SET title = $1, about = $2, version = version + 1
-        WHERE id = $4 AND version = $5
+        UPDATE reviews
+        SET rating = $1, title = $2, about = $3, status = $4, flags = $5, rejection_reason = '',
+            moderated_by = NULL, moderated_at = NULL, version = version + 1
+        WHERE review_id = $6 AND version = $7
         RETURNING version`
 
 	args := []interface{}{
+		review.Rating,
 		review.Title,
 		review.About,
+		review.Status,
+		pq.Array(review.Flags),
 		review.ReviewId,
 		review.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Begin a transaction
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
 	defer cancel()
 
-	err := r.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.Version)
 	if err != nil {
+		tx.Rollback()
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return ErrEditConflict
@@ -132,5 +242,178 @@ func (r ReviewModel) Update(review *Review) error {
 		}
 	}
 
+	err = refreshProductRating(ctx, tx, review.ProductId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	review.RejectionReason = ""
+	review.ModeratedBy = nil
+	review.ModeratedAt = nil
+
 	return nil
 }
+
+// Moderate records an admin's decision on a pending review and refreshes the product's
+// rating in the same transaction. A review that is no longer pending, or has changed
+// since it was read, is reported as an edit conflict.
+func (r ReviewModel) Moderate(review *Review, status, reason string, moderatorId int64) error {
+	query := `
+        UPDATE reviews
+        SET status = $1, rejection_reason = $2, moderated_by = $3, moderated_at = NOW(), version = version + 1
+        WHERE review_id = $4 AND version = $5 AND status = 'pending'
+        RETURNING moderated_at, version`
+
+	args := []interface{}{status, reason, moderatorId, review.ReviewId, review.Version}
+
+	// Begin a transaction
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ModeratedAt, &review.Version)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = refreshProductRating(ctx, tx, review.ProductId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	review.Status = status
+	review.RejectionReason = reason
+	review.ModeratedBy = &moderatorId
+
+	return nil
+}
+
+// HasDuplicateText reports whether the user has posted the same review text on another
+// review, which is a common sign of copy and paste spam.
+func (r ReviewModel) HasDuplicateText(userId, reviewId int64, about string) (bool, error) {
+	query := `
+        SELECT EXISTS (
+            SELECT 1
+            FROM reviews
+            WHERE user_id = $1
+            AND review_id <> $2
+            AND lower(trim(about)) = lower(trim($3))
+        )`
+
+	var exists bool
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := r.DB.QueryRowContext(ctx, query, userId, reviewId, about).Scan(&exists)
+
+	return exists, err
+}
+
+// GetQueue lists the reviews waiting for an admin, oldest first by default.
+func (r ReviewModel) GetQueue(filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), review_id, COALESCE(user_id, 0), product_id, rating, title, about, status, flags, rejection_reason, moderated_by, moderated_at, created_at, version
+        FROM reviews
+        WHERE status = 'pending'
+        ORDER BY %s %s, review_id ASC
+        LIMIT $1 OFFSET $2`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	return scanReviews(rows, filters)
+}
+
+// GetAllForProduct lists the product's approved reviews.
+func (r ReviewModel) GetAllForProduct(productId int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), review_id, COALESCE(user_id, 0), product_id, rating, title, about, status, flags, rejection_reason, moderated_by, moderated_at, created_at, version
+        FROM reviews
+        WHERE product_id = $1
+        AND status = 'approved'
+        ORDER BY %s %s, review_id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{productId, filters.limit(), filters.offset()}
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	return scanReviews(rows, filters)
+}
+
+func scanReviews(rows *sql.Rows, filters Filters) ([]*Review, Metadata, error) {
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ReviewId,
+			&review.UserId,
+			&review.ProductId,
+			&review.Rating,
+			&review.Title,
+			&review.About,
+			&review.Status,
+			pq.Array(&review.Flags),
+			&review.RejectionReason,
+			&review.ModeratedBy,
+			&review.ModeratedAt,
+			&review.CreatedAt,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Security event types.
+const (
+	SecurityEventLoginSucceeded     = "login_succeeded"
+	SecurityEventLoginFailed        = "login_failed"
+	SecurityEventLoginReported      = "login_reported"
+	SecurityEventTokenCreated       = "token_created"
+	SecurityEventAccountActivated   = "account_activated"
+	SecurityEventPasswordReset      = "password_reset"
+	SecurityEventPasswordChanged    = "password_changed"
+	SecurityEventPermissionsChanged = "permissions_changed"
+	SecurityEventDeletionRequested  = "deletion_requested"
+	SecurityEventDeletionCancelled  = "deletion_cancelled"
+	SecurityEventAccountErased      = "account_erased"
+)
+
+var SecurityEventTypes = []string{
+	SecurityEventLoginSucceeded,
+	SecurityEventLoginFailed,
+	SecurityEventLoginReported,
+	SecurityEventTokenCreated,
+	SecurityEventAccountActivated,
+	SecurityEventPasswordReset,
+	SecurityEventPasswordChanged,
+	SecurityEventPermissionsChanged,
+	SecurityEventDeletionRequested,
+	SecurityEventDeletionCancelled,
+	SecurityEventAccountErased,
+}
+
+// SecurityEvent records something that happened to an account. UserId is 0 for a failed
+// login with an email address nobody has, and ActorId is 0 unless someone other than the
+// user caused the event.
+type SecurityEvent struct {
+	EventId   int64     `json:"event_id"`
+	UserId    int64     `json:"user_id,omitempty"`
+	ActorId   int64     `json:"actor_id,omitempty"`
+	Type      string    `json:"type"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SecurityEventFilter narrows down a search of the log. Zero values match everything.
+type SecurityEventFilter struct {
+	UserId int64
+	Type   string
+	From   time.Time
+	To     time.Time
+}
+
+type SecurityEventModel struct {
+	DB *sql.DB
+}
+
+// insertSecurityEvent appends the event to the log using the caller's transaction, so that
+// an action and the record of it are committed together or not at all.
+func insertSecurityEvent(ctx context.Context, tx *sql.Tx, event *SecurityEvent) error {
+	query := `
+        INSERT INTO security_events (user_id, actor_id, event_type, ip, user_agent, details)
+        VALUES (NULLIF($1, 0), NULLIF($2, 0), $3, $4, $5, $6)
+        RETURNING event_id, created_at`
+
+	args := []interface{}{event.UserId, event.ActorId, event.Type, event.IP, event.UserAgent, event.Details}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&event.EventId, &event.CreatedAt)
+}
+
+// forgetSecurityEventOrigins blanks the IP address and user agent of the events the user
+// caused, which is the one change the append-only log allows. Events someone else caused
+// keep theirs.
+func forgetSecurityEventOrigins(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `
+        UPDATE security_events
+        SET ip = '', user_agent = ''
+        WHERE ((user_id = $1 AND actor_id IS NULL) OR actor_id = $1)
+        AND (ip <> '' OR user_agent <> '')`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
+// Record appends the event to the log on its own, for events that aren't part of a
+// change made elsewhere.
+func (m SecurityEventModel) Record(event *SecurityEvent) error {
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = insertSecurityEvent(ctx, tx, event)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Search lists the events matching the filter, newest first by default.
+func (m SecurityEventModel) Search(filter SecurityEventFilter, filters Filters) ([]*SecurityEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), event_id, COALESCE(user_id, 0), COALESCE(actor_id, 0), event_type, ip, user_agent, details, created_at
+        FROM security_events
+        WHERE (user_id = $1 OR $1 = 0)
+        AND (event_type = $2 OR $2 = '')
+        AND (created_at >= $3 OR $3 IS NULL)
+        AND (created_at < $4 OR $4 IS NULL)
+        ORDER BY %s %s, event_id DESC
+        LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{filter.UserId, filter.Type, nullTime(filter.From), nullTime(filter.To), filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*SecurityEvent{}
+
+	for rows.Next() {
+		var event SecurityEvent
+
+		err := rows.Scan(
+			&totalRecords,
+			&event.EventId,
+			&event.UserId,
+			&event.ActorId,
+			&event.Type,
+			&event.IP,
+			&event.UserAgent,
+			&event.Details,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return events, metadata, nil
+}
+
+// nullTime turns a zero time into NULL, for queries that treat NULL as no limit.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,497 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"marketier/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrUnknownParticipant = errors.New("unknown participant")
+)
+
+// Thread is a conversation between two or more users. UnreadCount is from the point of
+// view of the user the thread was loaded for.
+type Thread struct {
+	ThreadId      int64          `json:"thread_id"`
+	Subject       string         `json:"subject"`
+	CreatedBy     int64          `json:"created_by"`
+	Participants  []*Participant `json:"participants"`
+	UnreadCount   int            `json:"unread_count"`
+	CreatedAt     time.Time      `json:"created_at"`
+	LastMessageAt time.Time      `json:"last_message_at"`
+}
+
+type Participant struct {
+	UserId            int64      `json:"user_id"`
+	LastReadMessageId int64      `json:"last_read_message_id"`
+	LastReadAt        *time.Time `json:"last_read_at,omitempty"`
+}
+
+// Message is a single post in a thread. ReadBy lists the other participants who have
+// read up to or past the message.
+type Message struct {
+	MessageId int64     `json:"message_id"`
+	ThreadId  int64     `json:"thread_id"`
+	SenderId  int64     `json:"sender_id"`
+	Body      string    `json:"body"`
+	ReadBy    []int64   `json:"read_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UnreadNotice describes a participant's unread messages in a thread that they haven't
+// been emailed about yet.
+type UnreadNotice struct {
+	UserId          int64
+	Email           string
+	FirstName       string
+	ThreadId        int64
+	Subject         string
+	Unread          int
+	LatestMessageId int64
+}
+
+func ValidateThread(v *validator.Validator, thread *Thread) {
+	v.Check(thread.Subject != "", "subject", "must be provided")
+	v.Check(len(thread.Subject) <= 250, "subject", "must not be more than 250 bytes long")
+	v.Check(len(thread.Participants) >= 2, "participant_ids", "must contain at least one other user")
+	v.Check(len(thread.Participants) <= 10, "participant_ids", "must not contain more than 9 other users")
+}
+
+func ValidateMessage(v *validator.Validator, message *Message) {
+	v.Check(message.Body != "", "body", "must be provided")
+	v.Check(len(message.Body) <= 4000, "body", "must not be more than 4000 bytes long")
+}
+
+type ThreadModel struct {
+	DB *sql.DB
+}
+
+// Insert creates the thread, its participants and its first message in a single
+// transaction. The creator has read the first message by definition.
+func (m ThreadModel) Insert(thread *Thread, message *Message) error {
+	threadQuery := `
+        INSERT INTO threads (subject, created_by)
+        VALUES ($1, $2)
+        RETURNING thread_id, created_at, last_message_at`
+
+	participantQuery := `
+        INSERT INTO thread_participants (thread_id, user_id)
+        VALUES ($1, $2)`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, threadQuery, thread.Subject, thread.CreatedBy).Scan(&thread.ThreadId, &thread.CreatedAt, &thread.LastMessageAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, participant := range thread.Participants {
+		_, err = tx.ExecContext(ctx, participantQuery, thread.ThreadId, participant.UserId)
+		if err != nil {
+			tx.Rollback()
+			switch {
+			case err.Error() == `pq: insert or update on table "thread_participants" violates foreign key constraint "thread_participants_user_id_fkey"`:
+				return ErrUnknownParticipant
+			default:
+				return err
+			}
+		}
+	}
+
+	message.ThreadId = thread.ThreadId
+
+	err = insertMessage(ctx, tx, message)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	thread.LastMessageAt = message.CreatedAt
+
+	for _, participant := range thread.Participants {
+		if participant.UserId == message.SenderId {
+			participant.LastReadMessageId = message.MessageId
+			participant.LastReadAt = &message.CreatedAt
+		}
+	}
+
+	return tx.Commit()
+}
+
+// insertMessage adds the message to its thread using the caller's transaction, bumps the
+// thread's last message time and marks the thread read for the sender.
+func insertMessage(ctx context.Context, tx *sql.Tx, message *Message) error {
+	query := `
+        INSERT INTO messages (thread_id, sender_id, body)
+        VALUES ($1, $2, $3)
+        RETURNING message_id, created_at`
+
+	threadQuery := `
+        UPDATE threads
+        SET last_message_at = $1
+        WHERE thread_id = $2`
+
+	readQuery := `
+        UPDATE thread_participants
+        SET last_read_message_id = $1, last_read_at = $2
+        WHERE thread_id = $3 AND user_id = $4`
+
+	err := tx.QueryRowContext(ctx, query, message.ThreadId, message.SenderId, message.Body).Scan(&message.MessageId, &message.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, threadQuery, message.CreatedAt, message.ThreadId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, readQuery, message.MessageId, message.CreatedAt, message.ThreadId, message.SenderId)
+	if err != nil {
+		return err
+	}
+
+	message.ReadBy = []int64{}
+
+	return nil
+}
+
+func (m ThreadModel) InsertMessage(message *Message) error {
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	err = insertMessage(ctx, tx, message)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetForUser returns the thread with its participants and the user's unread count. Users
+// who aren't taking part in the thread get ErrRecordNotFound, so that the thread's
+// existence isn't given away.
+func (m ThreadModel) GetForUser(threadId, userId int64) (*Thread, error) {
+	if threadId < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT threads.thread_id, subject, COALESCE(created_by, 0) AS created_by, created_at, last_message_at,
+            (SELECT count(*) FROM messages
+             WHERE messages.thread_id = threads.thread_id
+             AND messages.message_id > thread_participants.last_read_message_id
+             AND messages.sender_id IS DISTINCT FROM thread_participants.user_id)
+        FROM threads
+        INNER JOIN thread_participants ON thread_participants.thread_id = threads.thread_id
+        WHERE threads.thread_id = $1
+        AND thread_participants.user_id = $2`
+
+	var thread Thread
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, threadId, userId).Scan(
+		&thread.ThreadId,
+		&thread.Subject,
+		&thread.CreatedBy,
+		&thread.CreatedAt,
+		&thread.LastMessageAt,
+		&thread.UnreadCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	thread.Participants, err = m.getParticipants(threadId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &thread, nil
+}
+
+func (m ThreadModel) getParticipants(threadId int64) ([]*Participant, error) {
+	query := `
+        SELECT user_id, last_read_message_id, last_read_at
+        FROM thread_participants
+        WHERE thread_id = $1
+        ORDER BY user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, threadId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := []*Participant{}
+
+	for rows.Next() {
+		var participant Participant
+
+		err := rows.Scan(&participant.UserId, &participant.LastReadMessageId, &participant.LastReadAt)
+		if err != nil {
+			return nil, err
+		}
+
+		participants = append(participants, &participant)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return participants, nil
+}
+
+// GetAllForUser lists the threads the user takes part in, with their unread counts.
+func (m ThreadModel) GetAllForUser(userId int64, unreadOnly bool, filters Filters) ([]*Thread, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), thread_id, subject, created_by, created_at, last_message_at, unread_count
+        FROM (
+            SELECT threads.thread_id, subject, COALESCE(created_by, 0) AS created_by, created_at, last_message_at,
+                (SELECT count(*) FROM messages
+                 WHERE messages.thread_id = threads.thread_id
+                 AND messages.message_id > thread_participants.last_read_message_id
+                 AND messages.sender_id IS DISTINCT FROM thread_participants.user_id) AS unread_count
+            FROM threads
+            INNER JOIN thread_participants ON thread_participants.thread_id = threads.thread_id
+            WHERE thread_participants.user_id = $1
+        ) AS my_threads
+        WHERE (unread_count > 0 OR NOT $2)
+        ORDER BY %s %s, thread_id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{userId, unreadOnly, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	threads := []*Thread{}
+
+	for rows.Next() {
+		var thread Thread
+
+		err := rows.Scan(
+			&totalRecords,
+			&thread.ThreadId,
+			&thread.Subject,
+			&thread.CreatedBy,
+			&thread.CreatedAt,
+			&thread.LastMessageAt,
+			&thread.UnreadCount,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		threads = append(threads, &thread)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	for _, thread := range threads {
+		thread.Participants, err = m.getParticipants(thread.ThreadId)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return threads, metadata, nil
+}
+
+func (m ThreadModel) GetMessages(threadId int64, filters Filters) ([]*Message, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), message_id, thread_id, COALESCE(sender_id, 0), body, created_at,
+            ARRAY(SELECT thread_participants.user_id FROM thread_participants
+                  WHERE thread_participants.thread_id = messages.thread_id
+                  AND thread_participants.user_id IS DISTINCT FROM messages.sender_id
+                  AND thread_participants.last_read_message_id >= messages.message_id
+                  ORDER BY thread_participants.user_id)
+        FROM messages
+        WHERE thread_id = $1
+        ORDER BY %s %s, message_id ASC
+        LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{threadId, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	messages := []*Message{}
+
+	for rows.Next() {
+		var message Message
+
+		err := rows.Scan(
+			&totalRecords,
+			&message.MessageId,
+			&message.ThreadId,
+			&message.SenderId,
+			&message.Body,
+			&message.CreatedAt,
+			pq.Array(&message.ReadBy),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		messages = append(messages, &message)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return messages, metadata, nil
+}
+
+// MarkRead records that the user has read every message currently in the thread. The
+// read marker only ever moves forward.
+func (m ThreadModel) MarkRead(threadId, userId int64) error {
+	query := `
+        UPDATE thread_participants
+        SET last_read_message_id = GREATEST(last_read_message_id,
+                (SELECT COALESCE(MAX(message_id), 0) FROM messages WHERE thread_id = $1)),
+            last_read_at = NOW()
+        WHERE thread_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, threadId, userId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetUnreadNotices finds every participant with unread messages that arrived after they
+// were last emailed, one notice per participant and thread.
+func (m ThreadModel) GetUnreadNotices() ([]*UnreadNotice, error) {
+	query := `
+        SELECT base_users.user_id, base_users.email, base_users.first_name, threads.thread_id, threads.subject,
+            count(messages.message_id), MAX(messages.message_id)
+        FROM thread_participants
+        INNER JOIN base_users ON base_users.user_id = thread_participants.user_id
+        INNER JOIN threads ON threads.thread_id = thread_participants.thread_id
+        INNER JOIN messages ON messages.thread_id = thread_participants.thread_id
+            AND messages.message_id > thread_participants.last_read_message_id
+            AND messages.sender_id IS DISTINCT FROM thread_participants.user_id
+        GROUP BY base_users.user_id, threads.thread_id, thread_participants.last_notified_message_id
+        HAVING MAX(messages.message_id) > thread_participants.last_notified_message_id
+        ORDER BY base_users.user_id, threads.thread_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notices := []*UnreadNotice{}
+
+	for rows.Next() {
+		var notice UnreadNotice
+
+		err := rows.Scan(
+			&notice.UserId,
+			&notice.Email,
+			&notice.FirstName,
+			&notice.ThreadId,
+			&notice.Subject,
+			&notice.Unread,
+			&notice.LatestMessageId,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		notices = append(notices, &notice)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return notices, nil
+}
+
+// MarkNotified records that the participant has been emailed about every message in the
+// thread up to messageId.
+func (m ThreadModel) MarkNotified(threadId, userId, messageId int64) error {
+	query := `
+        UPDATE thread_participants
+        SET last_notified_message_id = GREATEST(last_notified_message_id, $1)
+        WHERE thread_id = $2 AND user_id = $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, messageId, threadId, userId)
+	return err
+}
@@ -0,0 +1,247 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TierThreshold holds the minimums a marketier must meet to reach a tier.
+type TierThreshold struct {
+	SalesGenerated int
+	AverageRating  float64
+	AccountAge     time.Duration
+}
+
+// TierPolicy lists the thresholds for tiers 1 and upwards, so the threshold for tier n is
+// at index n-1. Marketiers who don't meet the first threshold are tier 0.
+type TierPolicy []TierThreshold
+
+// Evaluate returns the highest tier whose thresholds, and those of every tier below it,
+// are all met.
+func (p TierPolicy) Evaluate(salesGenerated int, averageRating float64, accountAge time.Duration) int {
+	tier := 0
+
+	for i, threshold := range p {
+		if salesGenerated < threshold.SalesGenerated || averageRating < threshold.AverageRating || accountAge < threshold.AccountAge {
+			break
+		}
+		tier = i + 1
+	}
+
+	return tier
+}
+
+func (p TierPolicy) String() string {
+	parts := make([]string, len(p))
+	for i, threshold := range p {
+		parts[i] = fmt.Sprintf("%d:%g:%d", threshold.SalesGenerated, threshold.AverageRating, int(threshold.AccountAge.Hours()/24))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// ParseTierPolicy reads a policy written as space separated "sales:rating:days" entries,
+// one per tier, starting at tier 1.
+func ParseTierPolicy(s string) (TierPolicy, error) {
+	fields := strings.Fields(s)
+	if len(fields) > 10 {
+		return nil, fmt.Errorf("tier policy must not have more than 10 tiers")
+	}
+
+	policy := TierPolicy{}
+
+	for i, field := range fields {
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("tier %d must be in the form sales:rating:days", i+1)
+		}
+
+		sales, err := strconv.Atoi(parts[0])
+		if err != nil || sales < 0 {
+			return nil, fmt.Errorf("tier %d sales must be a non-negative integer", i+1)
+		}
+
+		rating, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rating < 0 || rating > 5 {
+			return nil, fmt.Errorf("tier %d rating must be between 0 and 5", i+1)
+		}
+
+		days, err := strconv.Atoi(parts[2])
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("tier %d days must be a non-negative integer", i+1)
+		}
+
+		policy = append(policy, TierThreshold{
+			SalesGenerated: sales,
+			AverageRating:  rating,
+			AccountAge:     time.Duration(days) * 24 * time.Hour,
+		})
+	}
+
+	return policy, nil
+}
+
+var DefaultTierPolicy = TierPolicy{
+	{SalesGenerated: 1},
+	{SalesGenerated: 5, AccountAge: 7 * 24 * time.Hour},
+	{SalesGenerated: 10, AverageRating: 3, AccountAge: 30 * 24 * time.Hour},
+	{SalesGenerated: 25, AverageRating: 3, AccountAge: 60 * 24 * time.Hour},
+	{SalesGenerated: 50, AverageRating: 3.5, AccountAge: 90 * 24 * time.Hour},
+	{SalesGenerated: 100, AverageRating: 3.5, AccountAge: 180 * 24 * time.Hour},
+	{SalesGenerated: 250, AverageRating: 4, AccountAge: 270 * 24 * time.Hour},
+	{SalesGenerated: 500, AverageRating: 4, AccountAge: 365 * 24 * time.Hour},
+	{SalesGenerated: 1000, AverageRating: 4.5, AccountAge: 540 * 24 * time.Hour},
+	{SalesGenerated: 2500, AverageRating: 4.5, AccountAge: 730 * 24 * time.Hour},
+}
+
+type TierChange struct {
+	ChangeId    int64     `json:"change_id"`
+	MarketierId int64     `json:"marketier_id"`
+	OldTier     int       `json:"old_tier"`
+	NewTier     int       `json:"new_tier"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+type TierModel struct {
+	DB *sql.DB
+}
+
+// AverageRatings returns the average rating of the products each marketier promotes,
+// keyed by marketier. Each product counts once, however many links the marketier has for
+// it, and its exact average is used rather than the rounded stars. Marketiers without any
+// tracking links are left out.
+func (m TierModel) AverageRatings() (map[int64]float64, error) {
+	query := `
+        SELECT promoted.marketier_id, AVG(products.average_rating)
+        FROM (SELECT DISTINCT marketier_id, product_id FROM tracking_links) AS promoted
+        INNER JOIN products ON products.product_id = promoted.product_id
+        GROUP BY promoted.marketier_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := make(map[int64]float64)
+
+	for rows.Next() {
+		var marketierId int64
+		var rating float64
+
+		err := rows.Scan(&marketierId, &rating)
+		if err != nil {
+			return nil, err
+		}
+
+		ratings[marketierId] = rating
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ratings, nil
+}
+
+// ChangeTier moves the marketier to the change's new tier and records the change in their
+// history, in one transaction. The update only applies while the marketier is still on
+// the old tier, and returns ErrEditConflict otherwise. The tier is guarded rather than the
+// account version, which every profile edit bumps, so that an edit doesn't hold up a
+// tier change that's still right.
+func (m TierModel) ChangeTier(change *TierChange) error {
+	query := `
+        UPDATE marketiers
+        SET tier = $1
+        WHERE user_id = $2 AND tier = $3`
+
+	historyQuery := `
+        INSERT INTO tier_history (marketier_id, old_tier, new_tier)
+        VALUES ($1, $2, $3)
+        RETURNING change_id, changed_at`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	result, err := tx.ExecContext(ctx, query, change.NewTier, change.MarketierId, change.OldTier)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if rowsAffected == 0 {
+		tx.Rollback()
+		return ErrEditConflict
+	}
+
+	args := []interface{}{change.MarketierId, change.OldTier, change.NewTier}
+
+	err = tx.QueryRowContext(ctx, historyQuery, args...).Scan(&change.ChangeId, &change.ChangedAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m TierModel) GetHistory(marketierId int64) ([]*TierChange, error) {
+	query := `
+        SELECT change_id, marketier_id, old_tier, new_tier, changed_at
+        FROM tier_history
+        WHERE marketier_id = $1
+        ORDER BY changed_at DESC, change_id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, marketierId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*TierChange{}
+
+	for rows.Next() {
+		var change TierChange
+
+		err := rows.Scan(
+			&change.ChangeId,
+			&change.MarketierId,
+			&change.OldTier,
+			&change.NewTier,
+			&change.ChangedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, &change)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
@@ -6,15 +6,30 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"marketier/internal/validator"
+
+	"github.com/lib/pq"
 )
 
 const (
-	ScopeActivation     = "activation"
-	ScopeAuthentication = "authentication"
-	ScopePasswordReset  = "password-reset"
+	ScopeActivation        = "activation"
+	ScopeAuthentication    = "authentication"
+	ScopePasswordReset     = "password-reset"
+	ScopeRefresh           = "refresh"
+	ScopeMFAPending        = "mfa-pending"
+	ScopeUnlock            = "unlock"
+	ScopeMagicLink         = "magic-link"
+	ScopeEmailChange       = "email-change"
+	ScopeEmailChangeCancel = "email-change-cancel"
+	ScopeDataExport        = "data-export"
+	ScopeLoginReport       = "login-report"
+)
+
+var (
+	ErrRefreshTokenReused = errors.New("refresh token reused")
 )
 
 type Token struct {
@@ -23,6 +38,19 @@ type Token struct {
 	UserID    int64     `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	FamilyID  int64     `json:"-"`
+}
+
+// Session is a login on one device: a family made up of the refresh token issued at
+// login, the refresh tokens that replaced it, and the access tokens issued alongside them.
+type Session struct {
+	Id         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -68,10 +96,10 @@ func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 
 func (m TokenModel) Insert(token *Token) error {
 	query := `
-        INSERT INTO tokens (hash, user_id, expiry, scope) 
-        VALUES ($1, $2, $3, $4)`
+        INSERT INTO tokens (hash, user_id, expiry, scope, family_id) 
+        VALUES ($1, $2, $3, $4, NULLIF($5, 0))`
 
-	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []interface{}{token.Hash, token.UserID, token.Expiry, token.Scope, token.FamilyID}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -80,6 +108,53 @@ func (m TokenModel) Insert(token *Token) error {
 	return err
 }
 
+// insertToken issues a token in the scope using the caller's transaction, so that it's
+// only stored if the action it belongs to is.
+func insertToken(ctx context.Context, tx *sql.Tx, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	query := `
+        INSERT INTO tokens (hash, user_id, expiry, scope)
+        VALUES ($1, $2, $3, $4)`
+
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// deleteTokensForUser deletes the user's tokens in the given scopes using the caller's
+// transaction. With no scopes every token the user holds is deleted.
+func deleteTokensForUser(ctx context.Context, tx *sql.Tx, userID int64, scopes ...string) error {
+	query := `
+        DELETE FROM tokens
+        WHERE user_id = $1
+        AND (scope = ANY($2) OR cardinality($2::text[]) = 0)`
+
+	_, err := tx.ExecContext(ctx, query, userID, pq.Array(scopes))
+	if err != nil {
+		return err
+	}
+
+	return deleteEmptyFamilies(ctx, tx, userID)
+}
+
+// deleteEmptyFamilies removes the user's sessions that no longer have any tokens left.
+func deleteEmptyFamilies(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `
+        DELETE FROM token_families
+        WHERE user_id = $1
+        AND NOT EXISTS (SELECT 1 FROM tokens WHERE tokens.family_id = token_families.id)`
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	return err
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
         DELETE FROM tokens 
@@ -91,3 +166,350 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// RecordFailedAttempt counts a wrong code entered against the token, and deletes the token
+// once maxAttempts have been used up. It reports whether the token is gone, whether
+// because of this attempt or because it had already expired or been deleted.
+func (m TokenModel) RecordFailedAttempt(scope, tokenPlaintext string, maxAttempts int) (bool, error) {
+	query := `
+        UPDATE tokens
+        SET attempts = attempts + 1
+        WHERE hash = $1 AND scope = $2 AND expiry > NOW()
+        RETURNING attempts`
+
+	deleteQuery := `
+        DELETE FROM tokens
+        WHERE hash = $1`
+
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	var attempts int
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], scope).Scan(&attempts)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return true, nil
+		default:
+			return false, err
+		}
+	}
+
+	if attempts >= maxAttempts {
+		_, err = tx.ExecContext(ctx, deleteQuery, tokenHash[:])
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return attempts >= maxAttempts, nil
+}
+
+// CountRecentForUser counts the tokens with the scope issued to the user within the
+// given period, for limiting how often they can be sent out.
+func (m TokenModel) CountRecentForUser(scope string, userID int64, period time.Duration) (int, error) {
+	query := `
+        SELECT count(*)
+        FROM tokens
+        WHERE scope = $1 AND user_id = $2
+        AND created_at > NOW() - make_interval(secs => $3)`
+
+	var count int
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, scope, userID, period.Seconds()).Scan(&count)
+
+	return count, err
+}
+
+// insertTokenPair issues an access token and a refresh token in the family using the
+// caller's transaction.
+func insertTokenPair(ctx context.Context, tx *sql.Tx, userID, familyID int64, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	query := `
+        INSERT INTO tokens (hash, user_id, expiry, scope, family_id)
+        VALUES ($1, $2, $3, $4, $5)`
+
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*Token{access, refresh} {
+		token.FamilyID = familyID
+
+		_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.FamilyID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+// NewSession starts a new token family for a login from the given IP address and user
+// agent, returning its first access and refresh tokens.
+func (m TokenModel) NewSession(userID int64, accessTTL, refreshTTL time.Duration, ip, userAgent string) (*Token, *Token, error) {
+	query := `
+        INSERT INTO token_families (user_id, ip, user_agent)
+        VALUES ($1, $2, $3)
+        RETURNING id`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	var familyID int64
+
+	err = tx.QueryRowContext(ctx, query, userID, ip, userAgent).Scan(&familyID)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, familyID, accessTTL, refreshTTL)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// Rotate exchanges a refresh token for a new access and refresh token pair in the same
+// family. Each refresh token can only be exchanged once. Presenting one that has already
+// been used means it has leaked, so the whole family is revoked and ErrRefreshTokenReused
+// is returned.
+func (m TokenModel) Rotate(refreshPlaintext string, accessTTL, refreshTTL time.Duration) (*Token, *Token, error) {
+	tokenHash := sha256.Sum256([]byte(refreshPlaintext))
+
+	query := `
+        SELECT user_id, family_id, used_at IS NOT NULL
+        FROM tokens
+        WHERE hash = $1
+        AND scope = $2
+        AND expiry > NOW()
+        FOR UPDATE`
+
+	useQuery := `
+        UPDATE tokens
+        SET used_at = NOW()
+        WHERE hash = $1`
+
+	revokeQuery := `
+        DELETE FROM token_families
+        WHERE id = $1`
+
+	// Begin a transaction
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 6*time.Second)
+	defer cancel()
+
+	var userID, familyID int64
+	var used bool
+
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh).Scan(&userID, &familyID, &used)
+	if err != nil {
+		tx.Rollback()
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if used {
+		_, err = tx.ExecContext(ctx, revokeQuery, familyID)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, useQuery, tokenHash[:])
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	access, refresh, err := insertTokenPair(ctx, tx, userID, familyID, accessTTL, refreshTTL)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// Touch records that the session the access token belongs to has just been used, and
+// returns the session's id. To keep this from writing on every request, last-used is only
+// moved forward once it is more than a minute old.
+func (m TokenModel) Touch(tokenHash []byte) (int64, error) {
+	query := `
+        WITH current_token AS (
+            SELECT family_id FROM tokens WHERE hash = $1
+        ), touched AS (
+            UPDATE token_families
+            SET last_used_at = NOW()
+            WHERE id = (SELECT family_id FROM current_token)
+            AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+        )
+        SELECT COALESCE(family_id, 0) FROM current_token`
+
+	var familyID int64
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash).Scan(&familyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return familyID, nil
+}
+
+// GetSessions lists the user's sessions that can still be refreshed, most recently used
+// first. The session with id currentID is flagged as the current one.
+func (m TokenModel) GetSessions(userID, currentID int64) ([]*Session, error) {
+	query := `
+        SELECT token_families.id, token_families.created_at, token_families.last_used_at, MAX(tokens.expiry),
+            token_families.ip, token_families.user_agent, token_families.id = $3
+        FROM token_families
+        INNER JOIN tokens ON tokens.family_id = token_families.id
+        WHERE token_families.user_id = $1
+        AND tokens.scope = $2
+        AND tokens.used_at IS NULL
+        AND tokens.expiry > NOW()
+        GROUP BY token_families.id
+        ORDER BY COALESCE(token_families.last_used_at, token_families.created_at) DESC, token_families.id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeRefresh, currentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.Id,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.IP,
+			&session.UserAgent,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes one of the user's sessions along with every token in it.
+func (m TokenModel) DeleteSession(userID, sessionID int64) error {
+	query := `
+        DELETE FROM token_families
+        WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteAllSessions logs the user out everywhere by revoking every session they have.
+func (m TokenModel) DeleteAllSessions(userID int64) error {
+	query := `
+        DELETE FROM token_families
+        WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
@@ -0,0 +1,255 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type TrackingLink struct {
+	LinkId         int64     `json:"link_id"`
+	Code           string    `json:"code"`
+	MarketierId    int64     `json:"marketier_id"`
+	ProductId      int64     `json:"product_id"`
+	ProposalId     *int64    `json:"proposal_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	Clicks         int64     `json:"clicks"`
+	UniqueVisitors int64     `json:"unique_visitors"`
+}
+
+type Click struct {
+	ClickId     int64     `json:"click_id"`
+	LinkId      int64     `json:"link_id"`
+	ClickedAt   time.Time `json:"clicked_at"`
+	IPHash      []byte    `json:"-"`
+	UserAgent   string    `json:"user_agent"`
+	Referrer    string    `json:"referrer"`
+	UTMSource   string    `json:"utm_source"`
+	UTMMedium   string    `json:"utm_medium"`
+	UTMCampaign string    `json:"utm_campaign"`
+	UTMTerm     string    `json:"utm_term"`
+	UTMContent  string    `json:"utm_content"`
+}
+
+// generateTrackingCode returns a random 8 character code that is short enough to share
+// in a URL.
+func generateTrackingCode() (string, error) {
+	randomBytes := make([]byte, 5)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)), nil
+}
+
+type TrackingLinkModel struct {
+	DB *sql.DB
+}
+
+// insertTrackingLink returns the tracking link for the marketier and product inside the
+// caller's transaction, creating one if the pair doesn't have a link yet.
+func insertTrackingLink(ctx context.Context, tx *sql.Tx, marketierId, productId, proposalId int64) (*TrackingLink, error) {
+	query := `
+        INSERT INTO tracking_links (code, marketier_id, product_id, proposal_id)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (marketier_id, product_id) DO UPDATE SET marketier_id = EXCLUDED.marketier_id
+        RETURNING link_id, code, proposal_id, created_at`
+
+	link := &TrackingLink{
+		MarketierId: marketierId,
+		ProductId:   productId,
+	}
+
+	// Retry a few times in the unlikely event that the random code is already taken. A
+	// failed statement aborts the transaction, so each attempt is rolled back to a
+	// savepoint rather than losing the work done before it.
+	for attempt := 0; attempt < 3; attempt++ {
+		code, err := generateTrackingCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx, `SAVEPOINT tracking_link`)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tx.QueryRowContext(ctx, query, code, marketierId, productId, proposalId).Scan(&link.LinkId, &link.Code, &link.ProposalId, &link.CreatedAt)
+		if err != nil {
+			if err.Error() == `pq: duplicate key value violates unique constraint "tracking_links_code_key"` {
+				_, err = tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT tracking_link`)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, err
+		}
+
+		return link, nil
+	}
+
+	return nil, fmt.Errorf("unable to generate a unique tracking code for marketier %d and product %d", marketierId, productId)
+}
+
+func (m TrackingLinkModel) GetByCode(code string) (*TrackingLink, error) {
+	query := `
+        SELECT link_id, code, marketier_id, product_id, proposal_id, created_at
+        FROM tracking_links
+        WHERE code = $1`
+
+	var link TrackingLink
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, code).Scan(
+		&link.LinkId,
+		&link.Code,
+		&link.MarketierId,
+		&link.ProductId,
+		&link.ProposalId,
+		&link.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &link, nil
+}
+
+func (m TrackingLinkModel) Get(id int64) (*TrackingLink, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+        SELECT tracking_links.link_id, code, marketier_id, product_id, proposal_id, created_at,
+            count(tracking_clicks.click_id), count(DISTINCT tracking_clicks.ip_hash)
+        FROM tracking_links
+        LEFT JOIN tracking_clicks ON tracking_clicks.link_id = tracking_links.link_id
+        WHERE tracking_links.link_id = $1
+        GROUP BY tracking_links.link_id`
+
+	var link TrackingLink
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&link.LinkId,
+		&link.Code,
+		&link.MarketierId,
+		&link.ProductId,
+		&link.ProposalId,
+		&link.CreatedAt,
+		&link.Clicks,
+		&link.UniqueVisitors,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &link, nil
+}
+
+// GetAll lists tracking links with their click counts. A marketierId or ownerId of 0
+// disables that filter.
+func (m TrackingLinkModel) GetAll(marketierId, ownerId int64, filters Filters) ([]*TrackingLink, Metadata, error) {
+	query := fmt.Sprintf(`
+        SELECT count(*) OVER(), tracking_links.link_id, code, marketier_id, tracking_links.product_id, proposal_id, created_at,
+            count(tracking_clicks.click_id) AS clicks, count(DISTINCT tracking_clicks.ip_hash) AS unique_visitors
+        FROM tracking_links
+        INNER JOIN products ON products.product_id = tracking_links.product_id
+        LEFT JOIN tracking_clicks ON tracking_clicks.link_id = tracking_links.link_id
+        WHERE (marketier_id = $1 OR $1 = 0)
+        AND (products.owner_id = $2 OR $2 = 0)
+        GROUP BY tracking_links.link_id
+        ORDER BY %s %s, link_id ASC
+        LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []interface{}{marketierId, ownerId, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	links := []*TrackingLink{}
+
+	for rows.Next() {
+		var link TrackingLink
+
+		err := rows.Scan(
+			&totalRecords,
+			&link.LinkId,
+			&link.Code,
+			&link.MarketierId,
+			&link.ProductId,
+			&link.ProposalId,
+			&link.CreatedAt,
+			&link.Clicks,
+			&link.UniqueVisitors,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		links = append(links, &link)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return links, metadata, nil
+}
+
+func (m TrackingLinkModel) RecordClick(click *Click) error {
+	query := `
+        INSERT INTO tracking_clicks (link_id, ip_hash, user_agent, referrer, utm_source, utm_medium, utm_campaign, utm_term, utm_content)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING click_id, clicked_at`
+
+	args := []interface{}{
+		click.LinkId,
+		click.IPHash,
+		click.UserAgent,
+		click.Referrer,
+		click.UTMSource,
+		click.UTMMedium,
+		click.UTMCampaign,
+		click.UTMTerm,
+		click.UTMContent,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&click.ClickId, &click.ClickedAt)
+}
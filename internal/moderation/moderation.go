@@ -0,0 +1,174 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Flags raised by the rule engine. A text with no flags is considered clean.
+const (
+	FlagBannedWord     = "banned_word"
+	FlagLink           = "link"
+	FlagContactDetails = "contact_details"
+	FlagRepeatedText   = "repeated_text"
+)
+
+var (
+	LinkRX  = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|io|co|info|biz|shop|store|xyz)\b`)
+	EmailRX = regexp.MustCompile(`(?i)[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}`)
+	PhoneRX = regexp.MustCompile(`\+?\d[\d\s().-]{7,}\d`)
+
+	// DateRX matches numeric dates, either year first or year last, which are taken out
+	// before looking for phone numbers so that "2024-03-15" isn't mistaken for one.
+	DateRX = regexp.MustCompile(`\b(\d{4}[-./]\d{1,2}[-./]\d{1,2}|\d{1,2}[-./]\d{1,2}[-./]\d{4})\b`)
+
+	wordRX = regexp.MustCompile(`[\p{L}\p{N}']+`)
+)
+
+// Phone numbers have between 9 and 15 digits, counting the area and country codes.
+const (
+	minPhoneDigits = 9
+	maxPhoneDigits = 15
+)
+
+var DefaultBannedWords = []string{
+	"scam",
+	"fraud",
+	"idiot",
+	"stupid",
+	"moron",
+	"bastard",
+	"bitch",
+	"shit",
+	"fuck",
+	"crap",
+}
+
+type Engine struct {
+	bannedWords map[string]bool
+}
+
+func New(bannedWords []string) *Engine {
+	e := &Engine{bannedWords: make(map[string]bool)}
+
+	for _, word := range bannedWords {
+		e.bannedWords[strings.ToLower(word)] = true
+	}
+
+	return e
+}
+
+// Check runs every rule against the texts and returns the flags they raised, each flag
+// appearing at most once.
+func (e *Engine) Check(texts ...string) []string {
+	flags := []string{}
+
+	add := func(flag string) {
+		for _, f := range flags {
+			if f == flag {
+				return
+			}
+		}
+		flags = append(flags, flag)
+	}
+
+	for _, text := range texts {
+		if e.containsBannedWord(text) {
+			add(FlagBannedWord)
+		}
+
+		if LinkRX.MatchString(text) {
+			add(FlagLink)
+		}
+
+		if EmailRX.MatchString(text) || ContainsPhoneNumber(text) {
+			add(FlagContactDetails)
+		}
+
+		if IsRepetitive(text) {
+			add(FlagRepeatedText)
+		}
+	}
+
+	return flags
+}
+
+func (e *Engine) containsBannedWord(text string) bool {
+	for _, word := range wordRX.FindAllString(strings.ToLower(text), -1) {
+		if e.bannedWords[word] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ContainsPhoneNumber reports whether the text has something in it shaped like a phone
+// number: a run of digits and separators with as many digits as a phone number has, that
+// isn't a date.
+func ContainsPhoneNumber(text string) bool {
+	text = DateRX.ReplaceAllString(text, " ")
+
+	for _, match := range PhoneRX.FindAllString(text, -1) {
+		digits := 0
+		for _, r := range match {
+			if r >= '0' && r <= '9' {
+				digits++
+			}
+		}
+
+		if digits >= minPhoneDigits && digits <= maxPhoneDigits {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsRepetitive reports whether the text looks like filler: the same character typed over
+// and over, the same word repeated back to back, or a longer text made up of only a
+// handful of distinct words.
+func IsRepetitive(text string) bool {
+	var last rune
+	run := 0
+
+	for _, r := range text {
+		if r == last {
+			run++
+		} else {
+			last, run = r, 1
+		}
+
+		if run >= 6 && r != ' ' {
+			return true
+		}
+	}
+
+	words := wordRX.FindAllString(strings.ToLower(text), -1)
+
+	wordRun := 1
+	for i := 1; i < len(words); i++ {
+		if words[i] == words[i-1] {
+			wordRun++
+		} else {
+			wordRun = 1
+		}
+
+		if wordRun >= 4 {
+			return true
+		}
+	}
+
+	if len(words) >= 12 {
+		distinct := make(map[string]bool)
+		for _, word := range words {
+			distinct[word] = true
+		}
+
+		if len(distinct)*4 < len(words) {
+			return true
+		}
+	}
+
+	return false
+}
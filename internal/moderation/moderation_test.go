@@ -0,0 +1,94 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	engine := New(DefaultBannedWords)
+
+	tests := []struct {
+		name  string
+		texts []string
+		want  []string
+	}{
+		{"clean", []string{"Great product", "Arrived quickly and works as described."}, []string{}},
+		{"banned word", []string{"Total scam", "Don't buy it."}, []string{FlagBannedWord}},
+		{"banned word in any case", []string{"SCAM"}, []string{FlagBannedWord}},
+		{"banned word inside another word", []string{"Scampi was lovely"}, []string{}},
+		{"link with scheme", []string{"See https://example.com/deal"}, []string{FlagLink}},
+		{"link with www", []string{"See www.example.com"}, []string{FlagLink}},
+		{"bare domain", []string{"Cheaper at example.shop"}, []string{FlagLink}},
+		{"email address", []string{"Write to me at someone@example.org"}, []string{FlagLink, FlagContactDetails}},
+		{"phone number", []string{"Call me on 0412 345 678"}, []string{FlagContactDetails}},
+		{"international phone number", []string{"Call +44 20 7946 0958"}, []string{FlagContactDetails}},
+		{"iso date", []string{"Bought it on 2024-03-15 and it broke"}, []string{}},
+		{"iso date and time", []string{"Delivered 2024-03-15 10:30"}, []string{}},
+		{"day first date", []string{"Ordered 15/03/2024, arrived 18.03.2024"}, []string{}},
+		{"repeated characters", []string{"Loveeeeeeee it"}, []string{FlagRepeatedText}},
+		{"flags from every text, once each", []string{"scam scam", "what a scam"}, []string{FlagBannedWord}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := engine.Check(tt.texts...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.texts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsPhoneNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"0412 345 678", true},
+		{"(02) 9876 5432", true},
+		{"+1 (555) 123-4567", true},
+		{"+61.412.345.678", true},
+		{"on 2024-03-15 call 0412 345 678", true},
+		{"2024-03-15", false},
+		{"2024-03-15 10", false},
+		{"2024/3/5", false},
+		{"15-03-2024", false},
+		{"12345678", false},
+		{"1234 5678 9012 3456 7890", false},
+		{"It cost 1,299.99 in 2023", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := ContainsPhoneNumber(tt.text); got != tt.want {
+				t.Errorf("ContainsPhoneNumber(%q) = %t, want %t", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRepetitive(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"Works well and looks good", false},
+		{"!!!!!!", true},
+		{"soooooo good", true},
+		{"a      lot of spaces", false},
+		{"good good good good", true},
+		{"good good good", false},
+		{"buy buy now now buy buy now now buy buy now now", true},
+		{"The battery lasts all day and the screen is bright enough to read outside", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := IsRepetitive(tt.text); got != tt.want {
+				t.Errorf("IsRepetitive(%q) = %t, want %t", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package totp
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key from the test vectors in RFC 6238 appendix B, the ASCII
+// string "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC gives 8 digit codes. With 6 digits the code is the last 6 of them, since
+	// both are the same truncated value taken modulo a power of ten.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("Code at %d = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowercaseAndPaddedSecrets(t *testing.T) {
+	want, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"gezdgnbvgy3tqojqgezdgnbvgy3tqojq", rfcSecret + "===="} {
+		got, err := Code(secret, 1)
+		if err != nil {
+			t.Fatalf("Code(%q): %v", secret, err)
+		}
+
+		if got != want {
+			t.Errorf("Code(%q) = %q, want %q", secret, got, want)
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	for _, secret := range []string{"", "not base32!", "1"} {
+		_, err := Code(secret, 1)
+		if !errors.Is(err, ErrInvalidSecret) {
+			t.Errorf("Code(%q) error = %v, want ErrInvalidSecret", secret, err)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		want   bool
+	}{
+		{"current step", 0, true},
+		{"one step behind", -1, true},
+		{"one step ahead", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok, err := Validate(rfcSecret, code, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != tt.want {
+				t.Fatalf("Validate = %t, want %t", ok, tt.want)
+			}
+
+			if ok && step != current+tt.offset {
+				t.Errorf("Validate matched step %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"exact", "050471", true},
+		{"with spaces", "050 471", true},
+		{"wrong code", "050472", false},
+		{"too short", "05047", false},
+		{"rfc 8 digit code", "14050471", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok, err := Validate(rfcSecret, tt.code, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != tt.want {
+				t.Errorf("Validate(%q) = %t, want %t", tt.code, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q isn't unpadded base32: %v", secret, err)
+	}
+
+	if len(key) != secretLength {
+		t.Errorf("secret is %d bytes, want %d", len(key), secretLength)
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other == secret {
+		t.Error("GenerateSecret returned the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("MarkeTier", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("URI starts %s://%s, want otpauth://totp", uri.Scheme, uri.Host)
+	}
+
+	if uri.Path != "/MarkeTier:alice@example.com" {
+		t.Errorf("URI label = %q", uri.Path)
+	}
+
+	want := map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "MarkeTier",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+
+	params := uri.Query()
+	for key, value := range want {
+		if got := params.Get(key); got != value {
+			t.Errorf("URI %s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS products_search_idx ON products USING GIN (to_tsvector('simple', name || ' ' || about));
+CREATE INDEX IF NOT EXISTS products_stars_idx ON products (stars);
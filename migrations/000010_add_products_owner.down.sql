@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS products_owner_id_idx;
+
+ALTER TABLE products DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,7 @@
+-- Products created before owners were tracked have nothing to tell who they belonged to,
+-- so they're left without an owner rather than given to an arbitrary one. Only users who
+-- may manage every product can change them until an owner is assigned by hand. Every new
+-- product is created with its owner.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS owner_id bigint REFERENCES product_owners (user_id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS products_owner_id_idx ON products (owner_id);
@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS proposal_history;
+
+DROP INDEX IF EXISTS proposals_marketier_id_idx;
+DROP INDEX IF EXISTS proposals_product_id_idx;
+
+ALTER TABLE proposals DROP CONSTRAINT IF EXISTS proposals_status_check;
+
+ALTER TABLE proposals
+    DROP COLUMN IF EXISTS marketier_id,
+    DROP COLUMN IF EXISTS product_id,
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,31 @@
+-- Proposals from before they were tied to a marketier and a product have no one to act
+-- on them and nothing to move through the lifecycle, so they're removed before the
+-- columns are made NOT NULL.
+ALTER TABLE proposals
+    ADD COLUMN IF NOT EXISTS marketier_id bigint REFERENCES marketiers (user_id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS product_id bigint REFERENCES products (product_id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'draft',
+    ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+DELETE FROM proposals WHERE marketier_id IS NULL OR product_id IS NULL;
+
+ALTER TABLE proposals
+    ALTER COLUMN marketier_id SET NOT NULL,
+    ALTER COLUMN product_id SET NOT NULL;
+
+ALTER TABLE proposals ADD CONSTRAINT proposals_status_check CHECK (status IN ('draft', 'submitted', 'accepted', 'rejected', 'withdrawn', 'completed'));
+
+CREATE INDEX IF NOT EXISTS proposals_marketier_id_idx ON proposals (marketier_id);
+CREATE INDEX IF NOT EXISTS proposals_product_id_idx ON proposals (product_id);
+
+CREATE TABLE IF NOT EXISTS proposal_history (
+    event_id bigserial PRIMARY KEY,
+    proposal_id bigint NOT NULL REFERENCES proposals (proposal_id) ON DELETE CASCADE,
+    from_status text NOT NULL DEFAULT '',
+    to_status text NOT NULL,
+    actor_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS proposal_history_proposal_id_idx ON proposal_history (proposal_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tracking_clicks;
+DROP TABLE IF EXISTS tracking_links;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS tracking_links (
+    link_id bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL,
+    marketier_id bigint NOT NULL REFERENCES marketiers (user_id) ON DELETE CASCADE,
+    product_id bigint NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+    proposal_id bigint REFERENCES proposals (proposal_id) ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (marketier_id, product_id)
+);
+
+CREATE TABLE IF NOT EXISTS tracking_clicks (
+    click_id bigserial PRIMARY KEY,
+    link_id bigint NOT NULL REFERENCES tracking_links (link_id) ON DELETE CASCADE,
+    clicked_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    ip_hash bytea NOT NULL,
+    user_agent text NOT NULL DEFAULT '',
+    referrer text NOT NULL DEFAULT '',
+    utm_source text NOT NULL DEFAULT '',
+    utm_medium text NOT NULL DEFAULT '',
+    utm_campaign text NOT NULL DEFAULT '',
+    utm_term text NOT NULL DEFAULT '',
+    utm_content text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS tracking_clicks_link_id_clicked_at_idx ON tracking_clicks (link_id, clicked_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS tracking_clicks_ip_hash_idx;
+
+DROP TABLE IF EXISTS conversions;
@@ -0,0 +1,17 @@
+-- Conversions are financial records that the commission ledger points at, so they have to
+-- outlive the accounts, products and links they came from. Deleting any of those leaves
+-- the conversion in place with the reference cleared.
+CREATE TABLE IF NOT EXISTS conversions (
+    conversion_id bigserial PRIMARY KEY,
+    order_id text NOT NULL,
+    product_id bigint REFERENCES products (product_id) ON DELETE SET NULL,
+    owner_id bigint REFERENCES product_owners (user_id) ON DELETE SET NULL,
+    marketier_id bigint REFERENCES marketiers (user_id) ON DELETE SET NULL,
+    link_id bigint REFERENCES tracking_links (link_id) ON DELETE SET NULL,
+    click_id bigint REFERENCES tracking_clicks (click_id) ON DELETE SET NULL,
+    occurred_at timestamp with time zone NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (owner_id, order_id)
+);
+
+CREATE INDEX IF NOT EXISTS tracking_clicks_ip_hash_idx ON tracking_clicks (ip_hash, clicked_at);
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS ledger_postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS ledger_accounts;
+DROP FUNCTION IF EXISTS ledger_append_only();
+
+ALTER TABLE conversions
+    DROP COLUMN IF EXISTS order_total,
+    DROP COLUMN IF EXISTS commission,
+    DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,44 @@
+ALTER TABLE conversions
+    ADD COLUMN IF NOT EXISTS order_total bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS commission bigint NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS currency char(3) NOT NULL DEFAULT 'USD';
+
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    account_id bigserial PRIMARY KEY,
+    kind text NOT NULL,
+    user_id bigint REFERENCES base_users (user_id) ON DELETE SET NULL,
+    currency char(3) NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    UNIQUE (kind, user_id, currency)
+);
+
+CREATE TABLE IF NOT EXISTS journal_entries (
+    entry_id bigserial PRIMARY KEY,
+    description text NOT NULL,
+    conversion_id bigint REFERENCES conversions (conversion_id) ON DELETE RESTRICT,
+    created_at timestamp with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    posting_id bigserial PRIMARY KEY,
+    entry_id bigint NOT NULL REFERENCES journal_entries (entry_id) ON DELETE RESTRICT,
+    account_id bigint NOT NULL REFERENCES ledger_accounts (account_id) ON DELETE RESTRICT,
+    amount bigint NOT NULL,
+    currency char(3) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ledger_postings_account_id_idx ON ledger_postings (account_id);
+CREATE INDEX IF NOT EXISTS ledger_postings_entry_id_idx ON ledger_postings (entry_id);
+
+-- The ledger is append-only: corrections are made by posting a reversing entry.
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER journal_entries_append_only BEFORE UPDATE OR DELETE ON journal_entries
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+CREATE TRIGGER ledger_postings_append_only BEFORE UPDATE OR DELETE ON ledger_postings
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
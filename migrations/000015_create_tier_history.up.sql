@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS tier_history (
+    change_id bigserial PRIMARY KEY,
+    marketier_id bigint NOT NULL REFERENCES marketiers (user_id) ON DELETE CASCADE,
+    old_tier smallint NOT NULL,
+    new_tier smallint NOT NULL,
+    changed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS tier_history_marketier_id_idx ON tier_history (marketier_id);
@@ -0,0 +1,11 @@
+ALTER TABLE products DROP COLUMN IF EXISTS review_count;
+
+DROP INDEX IF EXISTS reviews_product_id_idx;
+
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_user_id_product_id_key;
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_rating_check;
+
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS product_id,
+    DROP COLUMN IF EXISTS rating,
+    DROP COLUMN IF EXISTS created_at;
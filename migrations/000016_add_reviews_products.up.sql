@@ -0,0 +1,29 @@
+-- Reviews as they were before they were tied to products. The baseline never created the
+-- table, so a database that has come this far may not have it.
+CREATE TABLE IF NOT EXISTS reviews (
+    review_id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    title text NOT NULL,
+    about text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Reviews from before they were tied to products have no product to belong to and no
+-- rating, so they're removed before the columns are made NOT NULL.
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS product_id bigint REFERENCES products (product_id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS rating smallint,
+    ADD COLUMN IF NOT EXISTS created_at timestamp(0) with time zone NOT NULL DEFAULT NOW();
+
+DELETE FROM reviews WHERE product_id IS NULL OR rating IS NULL;
+
+ALTER TABLE reviews
+    ALTER COLUMN product_id SET NOT NULL,
+    ALTER COLUMN rating SET NOT NULL;
+
+ALTER TABLE reviews ADD CONSTRAINT reviews_rating_check CHECK (rating BETWEEN 1 AND 5);
+ALTER TABLE reviews ADD CONSTRAINT reviews_user_id_product_id_key UNIQUE (user_id, product_id);
+
+CREATE INDEX IF NOT EXISTS reviews_product_id_idx ON reviews (product_id);
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS review_count integer NOT NULL DEFAULT 0;
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS reviews_status_idx;
+
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_status_check;
+
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS flags,
+    DROP COLUMN IF EXISTS rejection_reason,
+    DROP COLUMN IF EXISTS moderated_by,
+    DROP COLUMN IF EXISTS moderated_at;
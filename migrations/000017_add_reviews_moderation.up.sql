@@ -0,0 +1,13 @@
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'pending',
+    ADD COLUMN IF NOT EXISTS flags text[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS rejection_reason text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS moderated_by bigint REFERENCES base_users (user_id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS moderated_at timestamp(0) with time zone;
+
+ALTER TABLE reviews ADD CONSTRAINT reviews_status_check CHECK (status IN ('pending', 'approved', 'rejected'));
+
+-- Reviews written before moderation existed were already live.
+UPDATE reviews SET status = 'approved';
+
+CREATE INDEX IF NOT EXISTS reviews_status_idx ON reviews (status) WHERE status = 'pending';
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS thread_participants;
+DROP TABLE IF EXISTS threads;
+
+CREATE TABLE IF NOT EXISTS contacts (
+    contact_id bigserial PRIMARY KEY,
+    title text NOT NULL,
+    about text NOT NULL,
+    version int NOT NULL DEFAULT 1
+);
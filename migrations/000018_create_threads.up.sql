@@ -0,0 +1,30 @@
+DROP TABLE IF EXISTS contacts;
+
+CREATE TABLE IF NOT EXISTS threads (
+    thread_id bigserial PRIMARY KEY,
+    subject text NOT NULL,
+    created_by bigint REFERENCES base_users (user_id) ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_message_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS thread_participants (
+    thread_id bigint NOT NULL REFERENCES threads (thread_id) ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    last_read_message_id bigint NOT NULL DEFAULT 0,
+    last_read_at timestamp(0) with time zone,
+    last_notified_message_id bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (thread_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS thread_participants_user_id_idx ON thread_participants (user_id);
+
+CREATE TABLE IF NOT EXISTS messages (
+    message_id bigserial PRIMARY KEY,
+    thread_id bigint NOT NULL REFERENCES threads (thread_id) ON DELETE CASCADE,
+    sender_id bigint REFERENCES base_users (user_id) ON DELETE SET NULL,
+    body text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS messages_thread_id_idx ON messages (thread_id, message_id);
@@ -0,0 +1,69 @@
+-- The original permission tables pointed at a users table that never existed, so start
+-- them again against base_users.
+DROP TABLE IF EXISTS users_permissions;
+DROP TABLE IF EXISTS permissions;
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    code text UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    role_id bigserial PRIMARY KEY,
+    name text UNIQUE NOT NULL,
+    account_type smallint UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+-- Grants on top of whatever the user's role already allows.
+CREATE TABLE IF NOT EXISTS users_permissions (
+    user_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    granted_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, permission_id)
+);
+
+INSERT INTO permissions (code)
+VALUES
+    ('users:read'),
+    ('users:write'),
+    ('products:write'),
+    ('products:manage'),
+    ('proposals:write'),
+    ('proposals:respond'),
+    ('proposals:manage'),
+    ('reviews:write'),
+    ('reviews:moderate'),
+    ('threads:write'),
+    ('tracking:read'),
+    ('tracking:manage'),
+    ('conversions:write'),
+    ('ledger:read'),
+    ('permissions:write');
+
+INSERT INTO roles (name, account_type)
+VALUES
+    ('shopper', 1),
+    ('marketier', 2),
+    ('product_owner', 3),
+    ('admin', 4);
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.role_id, permissions.id
+FROM roles
+INNER JOIN permissions ON permissions.code = ANY (CASE roles.name
+    WHEN 'shopper' THEN ARRAY['reviews:write', 'threads:write']
+    WHEN 'marketier' THEN ARRAY['reviews:write', 'threads:write', 'proposals:write', 'tracking:read']
+    WHEN 'product_owner' THEN ARRAY['reviews:write', 'threads:write', 'users:read', 'products:write', 'proposals:respond', 'tracking:read', 'conversions:write']
+END);
+
+-- Administrators hold every permission.
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.role_id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin';
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS admin_audit (
+    entry_id bigserial PRIMARY KEY,
+    admin_id bigint REFERENCES base_users (user_id) ON DELETE SET NULL,
+    -- Not a foreign key, so that the trail outlives the accounts it describes.
+    target_user_id bigint NOT NULL,
+    action text NOT NULL,
+    reason text NOT NULL,
+    details text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_target_user_id_idx ON admin_audit (target_user_id);
+CREATE INDEX IF NOT EXISTS admin_audit_admin_id_idx ON admin_audit (admin_id);
+
+INSERT INTO permissions (code)
+VALUES ('users:manage');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.role_id, permissions.id
+FROM roles, permissions
+WHERE roles.name = 'admin'
+AND permissions.code = 'users:manage';
@@ -0,0 +1,21 @@
+-- Refresh tokens have nowhere to go without families. Access tokens get their session's
+-- details back.
+DELETE FROM tokens WHERE scope = 'refresh';
+
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS last_used_at timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS ip text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '';
+
+UPDATE tokens
+SET last_used_at = token_families.last_used_at, ip = token_families.ip, user_agent = token_families.user_agent
+FROM token_families
+WHERE token_families.id = tokens.family_id;
+
+DROP INDEX IF EXISTS tokens_family_id_idx;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS family_id,
+    DROP COLUMN IF EXISTS used_at;
+
+DROP TABLE IF EXISTS token_families;
@@ -0,0 +1,41 @@
+-- Sessions are now token families: a refresh token and everything issued when it was
+-- rotated. Each existing access token becomes a session of its own, keeping the details
+-- 000021 recorded for it, and lasts until it expires; the user then logs in again to get
+-- a refresh token.
+CREATE TABLE IF NOT EXISTS token_families (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_used_at timestamp(0) with time zone,
+    ip text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS token_families_user_id_idx ON token_families (user_id);
+
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS family_id bigint REFERENCES token_families (id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS used_at timestamp(0) with time zone;
+
+-- token_id only links each new family to the token it was made for, while they're being
+-- joined up.
+ALTER TABLE token_families ADD COLUMN token_id bigint;
+
+INSERT INTO token_families (user_id, created_at, last_used_at, ip, user_agent, token_id)
+SELECT user_id, created_at, last_used_at, ip, user_agent, id
+FROM tokens
+WHERE scope = 'authentication';
+
+UPDATE tokens
+SET family_id = token_families.id
+FROM token_families
+WHERE token_families.token_id = tokens.id;
+
+ALTER TABLE token_families DROP COLUMN token_id;
+
+ALTER TABLE tokens
+    DROP COLUMN IF EXISTS last_used_at,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS user_agent;
+
+CREATE INDEX IF NOT EXISTS tokens_family_id_idx ON tokens (family_id);
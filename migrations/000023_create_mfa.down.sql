@@ -0,0 +1,6 @@
+DELETE FROM permissions WHERE code = 'mfa:write';
+
+DELETE FROM tokens WHERE scope = 'mfa-pending';
+
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+    user_id bigint PRIMARY KEY REFERENCES base_users (user_id) ON DELETE CASCADE,
+    secret text NOT NULL,
+    -- NULL until the user has proven their authenticator app works.
+    confirmed_at timestamp(0) with time zone,
+    -- The last time step a code was accepted for, so that a code can't be replayed.
+    last_used_step bigint NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES user_mfa (user_id) ON DELETE CASCADE,
+    hash bytea NOT NULL,
+    used_at timestamp(0) with time zone,
+    UNIQUE (user_id, hash)
+);
+
+INSERT INTO permissions (code)
+VALUES ('mfa:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.role_id, permissions.id
+FROM roles, permissions
+WHERE roles.name IN ('marketier', 'product_owner', 'admin')
+AND permissions.code = 'mfa:write';
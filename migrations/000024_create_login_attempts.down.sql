@@ -0,0 +1,3 @@
+DELETE FROM tokens WHERE scope = 'unlock';
+
+DROP TABLE IF EXISTS login_attempts;
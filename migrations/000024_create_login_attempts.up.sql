@@ -0,0 +1,13 @@
+-- Failed logins are counted both per email address and per IP address. Email addresses are
+-- stored as typed, whether or not an account uses them, so that lockouts don't reveal
+-- which addresses are registered.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    kind text NOT NULL,
+    key text NOT NULL,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    blocked_until timestamp(0) with time zone,
+    PRIMARY KEY (kind, key)
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_at_idx ON login_attempts (last_failure_at);
@@ -0,0 +1,23 @@
+-- External OpenID Connect accounts linked to our users. The subject is the provider's
+-- stable ID for the user; email addresses can change on their side.
+CREATE TABLE IF NOT EXISTS user_identities (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    provider text NOT NULL,
+    subject text NOT NULL,
+    email text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_login_at timestamp(0) with time zone,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+
+-- Sign-ins in progress: the state sent to the provider, and the nonce it must put in the
+-- ID token it issues.
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash bytea PRIMARY KEY,
+    provider text NOT NULL,
+    nonce text NOT NULL,
+    expiry timestamp(0) with time zone NOT NULL
+);
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'api_keys:write';
+
+DROP TABLE IF EXISTS api_keys;
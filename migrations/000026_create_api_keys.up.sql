@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    name text NOT NULL,
+    -- The public part of the key, used to find it. Only a hash of the secret is kept.
+    prefix text UNIQUE NOT NULL,
+    hash bytea NOT NULL,
+    scopes text[] NOT NULL,
+    expiry timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+INSERT INTO permissions (code)
+VALUES ('api_keys:write');
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.role_id, permissions.id
+FROM roles, permissions
+WHERE roles.name IN ('product_owner', 'admin')
+AND permissions.code = 'api_keys:write';
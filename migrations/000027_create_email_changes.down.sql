@@ -0,0 +1,3 @@
+DELETE FROM tokens WHERE scope IN ('email-change', 'email-change-cancel');
+
+DROP TABLE IF EXISTS email_changes;
@@ -0,0 +1,8 @@
+-- A user's email address only changes once the new address has been confirmed. Until
+-- then the new address waits here, one pending change per user.
+CREATE TABLE IF NOT EXISTS email_changes (
+    user_id bigint PRIMARY KEY REFERENCES base_users (user_id) ON DELETE CASCADE,
+    new_email varchar(100) NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL
+);
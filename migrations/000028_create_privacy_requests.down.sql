@@ -0,0 +1,18 @@
+DROP TABLE IF EXISTS account_deletions;
+
+DELETE FROM tokens WHERE scope = 'data-export';
+
+DROP TABLE IF EXISTS data_exports;
+
+-- Reviews and proposal history left behind by deleted accounts can't be restored.
+DELETE FROM reviews WHERE user_id IS NULL;
+
+ALTER TABLE reviews ALTER COLUMN user_id SET NOT NULL;
+
+DELETE FROM proposal_history WHERE actor_id IS NULL;
+
+ALTER TABLE proposal_history
+    ALTER COLUMN actor_id SET NOT NULL,
+    DROP CONSTRAINT IF EXISTS proposal_history_actor_id_fkey,
+    ADD CONSTRAINT proposal_history_actor_id_fkey FOREIGN KEY (actor_id) REFERENCES base_users (user_id) ON DELETE CASCADE;
+
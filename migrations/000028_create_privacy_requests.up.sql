@@ -0,0 +1,27 @@
+-- A proposal's history stays intact when someone who acted on it is deleted.
+ALTER TABLE proposal_history
+    ALTER COLUMN actor_id DROP NOT NULL,
+    DROP CONSTRAINT IF EXISTS proposal_history_actor_id_fkey,
+    ADD CONSTRAINT proposal_history_actor_id_fkey FOREIGN KEY (actor_id) REFERENCES base_users (user_id) ON DELETE SET NULL;
+
+-- Reviews are kept, without their author, when the author's account is deleted.
+ALTER TABLE reviews ALTER COLUMN user_id DROP NOT NULL;
+
+-- The latest data export for each user. The archive itself is kept on disk.
+CREATE TABLE IF NOT EXISTS data_exports (
+    user_id bigint PRIMARY KEY REFERENCES base_users (user_id) ON DELETE CASCADE,
+    file_name text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expiry timestamp(0) with time zone NOT NULL
+);
+
+-- Accounts waiting out the grace period before they are erased. requested_by is the user
+-- themself or the administrator who asked for the deletion.
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id bigint PRIMARY KEY REFERENCES base_users (user_id) ON DELETE CASCADE,
+    requested_by bigint REFERENCES base_users (user_id) ON DELETE SET NULL,
+    requested_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    delete_after timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS account_deletions_delete_after_idx ON account_deletions (delete_after);
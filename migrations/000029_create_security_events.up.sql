@@ -0,0 +1,21 @@
+-- Authentication and account events, for answering who did what to an account and from
+-- where. user_id is the account the event is about and actor_id whoever caused it when
+-- that's someone else, such as an administrator. Neither is a foreign key, so that the
+-- log outlives the accounts it describes.
+CREATE TABLE IF NOT EXISTS security_events (
+    event_id bigserial PRIMARY KEY,
+    user_id bigint,
+    actor_id bigint,
+    event_type text NOT NULL,
+    ip text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    details text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS security_events_user_id_idx ON security_events (user_id, created_at);
+CREATE INDEX IF NOT EXISTS security_events_event_type_idx ON security_events (event_type, created_at);
+
+-- Like the ledger, the log is append-only.
+CREATE TRIGGER security_events_append_only BEFORE UPDATE OR DELETE ON security_events
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
@@ -0,0 +1,10 @@
+-- The devices each user has logged in from, so that a login from anywhere else can be
+-- reported to them. A device is a keyed hash of the IP address and user agent, so the
+-- addresses themselves aren't kept.
+CREATE TABLE IF NOT EXISTS known_devices (
+    user_id bigint NOT NULL REFERENCES base_users (user_id) ON DELETE CASCADE,
+    fingerprint bytea NOT NULL,
+    first_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_seen_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, fingerprint)
+);
@@ -0,0 +1,18 @@
+-- Postgres can't decrypt the values, so this only works on a database whose personal
+-- details are still plaintext. It refuses to run otherwise, rather than failing partway
+-- through converting ciphertext to a date; on a database with encrypted values the
+-- migration is irreversible.
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM base_users
+        WHERE date_of_birth LIKE 'enc:%' OR gender LIKE 'enc:%' OR address LIKE 'enc:%'
+    ) THEN
+        RAISE EXCEPTION 'personal details are encrypted and cannot be converted back';
+    END IF;
+END
+$$;
+
+ALTER TABLE base_users
+    ALTER COLUMN date_of_birth TYPE timestamp USING date_of_birth::timestamp,
+    ALTER COLUMN gender TYPE varchar(5);
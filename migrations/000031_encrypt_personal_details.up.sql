@@ -0,0 +1,6 @@
+-- Personal details are now encrypted by the application, so the columns hold text. Dates
+-- of birth are converted to the RFC 3339 format they're encrypted in, and existing values
+-- stay readable as plaintext until the API is run with -encryption-reencrypt.
+ALTER TABLE base_users
+    ALTER COLUMN date_of_birth TYPE text USING to_char(date_of_birth, 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+    ALTER COLUMN gender TYPE text;
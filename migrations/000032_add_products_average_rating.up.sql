@@ -0,0 +1,6 @@
+-- stars is the average rating rounded to whole stars, for filtering. average_rating keeps
+-- the exact average, so that 4.5 isn't shown as 5.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS average_rating numeric(3, 2) NOT NULL DEFAULT 0;
+
+UPDATE products
+SET average_rating = COALESCE((SELECT AVG(rating) FROM reviews WHERE reviews.product_id = products.product_id AND status = 'approved'), 0);
@@ -0,0 +1,11 @@
+INSERT INTO permissions (code)
+VALUES ('tiers:read');
+
+-- Held by whoever could already read other marketiers' tier history through ledger:read.
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles_permissions.role_id, tiers.id
+FROM roles_permissions
+INNER JOIN permissions AS ledger ON ledger.id = roles_permissions.permission_id
+CROSS JOIN permissions AS tiers
+WHERE ledger.code = 'ledger:read'
+AND tiers.code = 'tiers:read';
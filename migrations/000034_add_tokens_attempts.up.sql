@@ -0,0 +1,4 @@
+-- Wrong codes entered against a token, so that one that is being guessed can be thrown
+-- away.
+ALTER TABLE tokens
+    ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- When each user last asked for a data export, so that they can't ask again until the
+-- export interval has passed. Exports are expensive to build and are kept apart from
+-- data_exports, which only gets a row once the archive is ready.
+CREATE TABLE IF NOT EXISTS data_export_requests (
+    user_id bigint PRIMARY KEY REFERENCES base_users (user_id) ON DELETE CASCADE,
+    requested_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
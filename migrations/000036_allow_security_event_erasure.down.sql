@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS security_events_append_only ON security_events;
+
+CREATE TRIGGER security_events_append_only BEFORE UPDATE OR DELETE ON security_events
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+DROP FUNCTION IF EXISTS security_events_append_only();
@@ -0,0 +1,25 @@
+-- The security log stays append-only, except that erasing an account blanks the IP
+-- address and user agent of the events the user caused. Any other change to an event, or
+-- deleting one, is still refused.
+CREATE OR REPLACE FUNCTION security_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND NEW.event_id = OLD.event_id
+        AND NEW.user_id IS NOT DISTINCT FROM OLD.user_id
+        AND NEW.actor_id IS NOT DISTINCT FROM OLD.actor_id
+        AND NEW.event_type = OLD.event_type
+        AND NEW.details = OLD.details
+        AND NEW.created_at = OLD.created_at
+        AND NEW.ip = ''
+        AND NEW.user_agent = '' THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS security_events_append_only ON security_events;
+
+CREATE TRIGGER security_events_append_only BEFORE UPDATE OR DELETE ON security_events
+    FOR EACH ROW EXECUTE FUNCTION security_events_append_only();